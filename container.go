@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Container queries, https://www.w3.org/TR/css-contain-3/#container-queries
+// Elements with container-type are query containers, @container rules apply
+// to their descendants depending on the container size. The size is known only
+// after layout of the container box, so the layout styles its descendants again
+// and rebuilds their boxes before laying them out.
+
+// containerQuery is like "sidebar (min-width: 400px)", features are joined
+// by or if or is set, by and otherwise
+type containerQuery struct {
+	// name selects the nearest container with this container-name, empty selects the nearest one
+	name     string
+	not      bool
+	or       bool
+	features []mediaFeature
+}
+
+// queryContainer is an element with container-type, features are its sizes,
+// nil until its box is laid out
+type queryContainer struct {
+	names []string
+	// inline is set for inline-size containers, which have no height features
+	inline   bool
+	features *featureValues
+	// styler styled the container, and styles its descendants again
+	styler *styler
+	// content is the state of content generation at the container,
+	// content of its descendants is generated from it once they are styled again
+	content *contentGenerator
+}
+
+// newQueryContainer returns the container for computed values,
+// nil if container-type is normal
+func newQueryContainer(computed propertyMap) *queryContainer {
+	containerType := computed["container-type"]
+	if !containerType.isKeyword("size") && !containerType.isKeyword("inline-size") {
+		return nil
+	}
+	c := &queryContainer{inline: containerType.isKeyword("inline-size")}
+	names := computed["container-name"]
+	if names.valueType == ListValue {
+		for _, name := range names.list {
+			if name.valueType == Keyword {
+				c.names = append(c.names, name.keyword)
+			}
+		}
+	} else if names.valueType == Keyword && !names.isKeyword("none") {
+		c.names = append(c.names, names.keyword)
+	}
+	return c
+}
+
+func (c *queryContainer) hasName(name string) bool {
+	if name == "" {
+		return true
+	}
+	for _, n := range c.names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// sizeFeatures returns features of the container with the content width of its box,
+// height is the specified one, as size containment makes it independent of the content
+func (c *queryContainer) sizeFeatures(width float32, styled *styledNode) *featureValues {
+	features := &featureValues{
+		ranges:   map[string]float32{"width": width, "inline-size": width},
+		discrete: map[string]string{},
+	}
+	if !c.inline {
+		var height float32
+		if h := styled.lookupOr("height", Value{keyword: "auto"}); h.valueType == Length && h.unitType != Percent {
+			height = h.toPx()
+		}
+		features.ranges["height"], features.ranges["block-size"] = height, height
+		features.discrete["orientation"] = orientation(width, height)
+	}
+	return features
+}
+
+// matchesContainers tells if all @container rules around the rule match the node,
+// or its pseudo-element if pseudo is not empty
+func (r *Rule) matchesContainers(node *Node, pseudo string, containers map[*Node]*queryContainer) bool {
+	if len(r.containers) == 0 {
+		return true
+	}
+	start := node.Parent
+	if pseudo != "" {
+		start = node
+	}
+	for _, q := range r.containers {
+		if !q.matches(start, containers) {
+			return false
+		}
+	}
+	return true
+}
+
+// matches evaluates the query against the nearest container of the name from node up.
+// The result is unknown, so the query doesn't match, without such container,
+// before the container is laid out, or for features it doesn't have.
+func (q containerQuery) matches(node *Node, containers map[*Node]*queryContainer) bool {
+	for n := node; n != nil; n = n.Parent {
+		c, ok := containers[n]
+		if !ok || !c.hasName(q.name) {
+			continue
+		}
+		if c.features == nil {
+			return false
+		}
+		result := !q.or
+		for _, f := range q.features {
+			matches, known := f.evaluate(*c.features)
+			if !known {
+				return false
+			}
+			if q.or {
+				result = result || matches
+			} else {
+				result = result && matches
+			}
+		}
+		return result != q.not
+	}
+	return false
+}
+
+// hasContainerRules tells if some sheet has @container rules
+func hasContainerRules(sheets []*Stylesheet) bool {
+	for _, sheet := range sheets {
+		for _, rule := range sheet.rules {
+			if len(rule.containers) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// updateContainer makes the styled node a query container if it has container-type
+// and the sheets have @container rules. Sizes of the container it replaces, or of the one
+// registered for the node before its ancestor was styled again, are kept if its type is the same,
+// so descendants aren't styled again by the layout unless its size changes.
+func (s *styler) updateContainer(styled *styledNode) {
+	if !s.containerRules || styled.node.NodeType != ElementNode {
+		return
+	}
+	c := newQueryContainer(styled.computedValues)
+	if c == nil {
+		styled.container = nil
+		delete(s.media.containers, styled.node)
+		return
+	}
+	old := styled.container
+	if old == nil {
+		old = s.media.containers[styled.node]
+	}
+	if old != nil && old.inline == c.inline {
+		c.features = old.features
+	}
+	c.styler = s
+	styled.container = c
+	if s.media.containers == nil {
+		s.media.containers = make(map[*Node]*queryContainer)
+	}
+	s.media.containers[styled.node] = c
+}
+
+// restyle styles descendants of the container again, with its current sizes,
+// and generates their content. Content after the container is generated again
+// by the document after layout.
+func (c *queryContainer) restyle(styled *styledNode) {
+	s := *c.styler
+	s.ctx = newMatchContext()
+	s.ancestors = new(ancestorFilter)
+	for n := styled.node.Parent; n != nil; n = n.Parent {
+		if n.NodeType == ElementNode {
+			s.ancestors.push(n)
+		}
+	}
+	styled.children = []*styledNode{}
+	s.styleChildren(styled, nil)
+	g := &contentGenerator{counters: make(map[string][]int)}
+	if c.content != nil {
+		g = c.content.clone()
+	}
+	g.visitChildren(styled)
+}
+
+// resolveContainerQueries gives the container its size, once its width is known,
+// and if the size has changed, styles its descendants again and rebuilds their boxes
+func (box *layoutBox) resolveContainerQueries() {
+	styled := box.styledNode
+	if styled == nil || styled.container == nil {
+		return
+	}
+	c := styled.container
+	features := c.sizeFeatures(box.dimensions.content.width, styled)
+	if reflect.DeepEqual(features, c.features) {
+		return
+	}
+	c.features = features
+	c.restyle(styled)
+	if rebuilt := nodesToBoxes(styled); rebuilt != nil {
+		box.children = rebuilt.children
+	}
+}
+
+// parseContainerQuery parses [name] [not] (feature) [and (feature)]*,
+// or features joined by or, up to {
+func parseContainerQuery(r *bufio.Reader) (containerQuery, error) {
+	q := containerQuery{}
+	skipSpaces(r)
+	word := ""
+	if !isNextChar(r, '(') {
+		word = readIdent(r)
+	}
+	if word != "" && !strings.EqualFold(word, "not") {
+		switch strings.ToLower(word) {
+		case "none", "and", "or":
+			return q, fmt.Errorf("%q can't be container name", word)
+		}
+		q.name, word = word, ""
+		skipSpaces(r)
+		if !isNextChar(r, '(') {
+			word = readIdent(r)
+		}
+	}
+	if strings.EqualFold(word, "not") {
+		q.not = true
+		skipSpaces(r)
+	} else if word != "" {
+		return q, fmt.Errorf("container condition is expected instead of %q", word)
+	}
+	for {
+		f, err := parseMediaFeature(r)
+		if err != nil {
+			return q, err
+		}
+		q.features = append(q.features, f)
+		skipSpaces(r)
+		if isNextChar(r, '{') {
+			return q, nil
+		}
+		join := strings.ToLower(readIdent(r))
+		if q.not || join != "and" && join != "or" || len(q.features) > 1 && (join == "or") != q.or {
+			return q, fmt.Errorf("{ is expected after container condition")
+		}
+		q.or = join == "or"
+		skipSpaces(r)
+	}
+}
+
+// String serializes the query like "sidebar (min-width: 400px)"
+func (q containerQuery) String() string {
+	return q.format(false)
+}
+
+func (q containerQuery) format(minify bool) string {
+	parts := []string{}
+	if q.name != "" {
+		parts = append(parts, q.name)
+	}
+	if q.not {
+		parts = append(parts, "not")
+	}
+	join := "and"
+	if q.or {
+		join = "or"
+	}
+	for i, f := range q.features {
+		if i > 0 {
+			parts = append(parts, join)
+		}
+		parts = append(parts, f.format(minify))
+	}
+	return strings.Join(parts, " ")
+}
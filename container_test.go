@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func Test_parseContainerQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"(min-width: 400px)", "(min-width: 400px)"},
+		{"card (width > 400px)", "card (width > 400px)"},
+		{"card not (400px <= width)", "card not (width >= 400px)"},
+		{"(width>=1px) and (height<100px)", "(width >= 1px) and (height < 100px)"},
+		{"sidebar (orientation: portrait) or (100px < inline-size < 200px)", "sidebar (orientation: portrait) or (100px < inline-size < 200px)"},
+	}
+	for _, tt := range tests {
+		q, err := parseContainerQuery(bufio.NewReader(strings.NewReader(tt.query + " {")))
+		if err != nil {
+			t.Fatal(tt.query, err)
+		}
+		if got := q.String(); got != tt.want {
+			t.Errorf("%q is serialized as %q", tt.query, got)
+		}
+	}
+	for _, query := range []string{"card", "none (width > 1px)", "(width > 1px) and (height > 1px) or (width < 2px)", "not (width > 1px) and (height > 1px)", "card narrow (width > 1px)"} {
+		if _, err := parseContainerQuery(bufio.NewReader(strings.NewReader(query + " {"))); err == nil {
+			t.Errorf("%q is accepted", query)
+		}
+	}
+}
+
+func TestContainerQueries(t *testing.T) {
+	html := `<div class="sidebar"><div class="card"><p>1</p><span class="wide">w</span></div></div>` +
+		`<div class="main"><div class="card"><p>2</p><span class="wide">w</span><div class="inner"><p>3</p></div></div></div>`
+	css := `div {display: block} .sidebar {width: 200px} .main {width: 600px}
+		.card {container-type: inline-size; container-name: card}
+		.inner {container-type: size; width: 150px; height: 300px}
+		@container card (min-width: 400px) { p {color: #000001} }
+		@container (width < 300px) { .wide {display: none} }
+		@container card (width >= 300px) { @container (orientation: portrait) { p {color: #000002} } }
+		@container card (height > 0px) { p {text-indent: 1px} }`
+	node, err := parseHTMLWrapped(strings.NewReader(html))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := NewStyledDocument(node, mustParseStylesheet(t, css))
+	sidebar, main := node.Children[0].Children[0], node.Children[1].Children[0]
+	p1, p2, p3 := sidebar.Children[0], main.Children[0], main.Children[2].Children[0]
+	for _, p := range []*Node{p1, p2, p3} {
+		if got := doc.ComputedStyle(p)["color"]; got != "rgb(0, 0, 0)" {
+			t.Errorf("%s has color %s before layout", p.Children[0].Data, got)
+		}
+	}
+	doc.Layout(800, 600)
+	tests := []struct {
+		node  *Node
+		color string
+	}{
+		{p1, "rgb(0, 0, 0)"},
+		{p2, "rgb(0, 0, 1)"},
+		{p3, "rgb(0, 0, 2)"},
+	}
+	for _, tt := range tests {
+		style := doc.ComputedStyle(tt.node)
+		if style["color"] != tt.color || style["text-indent"] != "0px" {
+			t.Errorf("%s has color %s, indent %s", tt.node.Children[0].Data, style["color"], style["text-indent"])
+		}
+	}
+	if doc.boxes[sidebar.Children[1]] != nil || doc.boxes[main.Children[1]] == nil {
+		t.Error("boxes are not rebuilt for display of the container query")
+	}
+
+	doc.SetAttribute(node.Children[0], "class", "main")
+	doc.Layout(800, 600)
+	if got := doc.ComputedStyle(p1)["color"]; got != "rgb(0, 0, 1)" {
+		t.Errorf("got %s after the container is resized", got)
+	}
+	inner := doc.styled[main.Children[2]].container
+	doc.SetAttribute(node.Children[1], "style", "width: 250px")
+	doc.Layout(800, 600)
+	if got := doc.ComputedStyle(p2)["color"]; got != "rgb(0, 0, 0)" || doc.boxes[main.Children[1]] != nil {
+		t.Errorf("got %s for the narrow container", got)
+	}
+	if restyled := doc.styled[main.Children[2]].container; restyled == inner || restyled.features != inner.features {
+		t.Error("sizes of the nested container are not kept when its ancestor is styled again")
+	}
+}
+
+func TestContainerContent(t *testing.T) {
+	html := `<section><p>a</p><div class="card"><p>b</p><p class="wide">c</p></div><p>d</p></section>`
+	css := `div {display: block} section {counter-reset: n} .card {container-type: inline-size; width: 300px}
+		p::before {counter-increment: n; content: counter(n) " "}
+		@container (min-width: 200px) { .wide::before {counter-increment: n 10} }`
+	node, err := parseHTMLWrapped(strings.NewReader(html))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := NewStyledDocument(node, mustParseStylesheet(t, css))
+	doc.Layout(800, 600)
+	section := node.Children[0]
+	card := section.Children[1]
+	want := []string{"1 ", "2 ", "12 ", "13 "}
+	for i, p := range []*Node{section.Children[0], card.Children[0], card.Children[1], section.Children[2]} {
+		if got := generatedText(doc.styled[p].children[0]); got != want[i] {
+			t.Errorf("%s: got counter %q, want %q", p.Children[0].Data, got, want[i])
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Generated content, https://www.w3.org/TR/css-content-3/
+// and counters, https://www.w3.org/TR/css-lists-3/#auto-numbering
+
+// contentGenerator walks styled tree in document order,
+// keeping counters and depth of nested quotes
+type contentGenerator struct {
+	// counters holds nested instances of each counter, the innermost is the last
+	counters   map[string][]int
+	quoteDepth int
+}
+
+var defaultQuotes = []string{"“", "”", "‘", "’"}
+
+// generateContent sets counters and fills ::before and ::after nodes
+// with the text of their content property
+func generateContent(root *styledNode) {
+	g := &contentGenerator{counters: make(map[string][]int)}
+	g.visitChildren(root)
+}
+
+// visitChildren visits children of the node. Counters created by them are in scope
+// for their following siblings too, so they are removed only after all children.
+func (g *contentGenerator) visitChildren(node *styledNode) {
+	created := make(map[string]int)
+	for _, child := range node.children {
+		g.visit(child, created)
+	}
+	for name, count := range created {
+		instances := g.counters[name]
+		g.counters[name] = instances[:len(instances)-count]
+	}
+}
+
+func (g *contentGenerator) visit(node *styledNode, created map[string]int) {
+	if node.node.NodeType != ElementNode {
+		return
+	}
+	for _, c := range counterList(node.computedValues["counter-reset"], 0) {
+		if created[c.name] > 0 {
+			// reset by preceding sibling, replace its value
+			g.counters[c.name][len(g.counters[c.name])-1] = c.value
+			continue
+		}
+		g.counters[c.name] = append(g.counters[c.name], c.value)
+		created[c.name]++
+	}
+	for _, c := range counterList(node.computedValues["counter-increment"], 1) {
+		if len(g.counters[c.name]) == 0 {
+			g.counters[c.name] = append(g.counters[c.name], 0)
+			created[c.name]++
+		}
+		g.counters[c.name][len(g.counters[c.name])-1] += c.value
+	}
+	if node.pseudo != "" {
+		g.fillContent(node)
+	}
+	if node.container != nil {
+		node.container.content = g.clone()
+	}
+	g.visitChildren(node)
+}
+
+// clone returns copy of the generator, which is not affected by changes of the generator
+func (g *contentGenerator) clone() *contentGenerator {
+	counters := make(map[string][]int, len(g.counters))
+	for name, instances := range g.counters {
+		counters[name] = append([]int(nil), instances...)
+	}
+	return &contentGenerator{counters: counters, quoteDepth: g.quoteDepth}
+}
+
+type counterChange struct {
+	name  string
+	value int
+}
+
+// counterList parses value like "chapter section 2", where each name
+// may be followed by an integer, otherwise def is used
+func counterList(v Value, def int) []counterChange {
+	items := []Value{v}
+	if v.valueType == ListValue {
+		items = v.list
+	}
+	result := []counterChange{}
+	for _, item := range items {
+		if item.valueType == Keyword && item.keyword != "none" {
+			result = append(result, counterChange{item.keyword, def})
+		} else if (item.valueType == Number || item.valueType == Length) && len(result) > 0 {
+			result[len(result)-1].value = int(item.length)
+		}
+	}
+	return result
+}
+
+// fillContent makes children of pseudo-element node from its content:
+// text for strings, attr(), counters and quotes, and img for url()
+func (g *contentGenerator) fillContent(node *styledNode) {
+	content := node.computedValues["content"]
+	items := []Value{content}
+	if content.valueType == ListValue {
+		items = content.list
+	}
+	// the content is generated again after restyle
+	node.children = []*styledNode{}
+	text := new(strings.Builder)
+	flushText := func() {
+		if text.Len() > 0 {
+			textNode := &Node{NodeType: TextNode, Data: text.String(), Children: []*Node{}, Attributes: make(map[string]string), Parent: node.node}
+			node.children = append(node.children, &styledNode{node: textNode, specifiedValues: propertyMap{}, computedValues: inheritedValues(node.computedValues), children: []*styledNode{}})
+			text.Reset()
+		}
+	}
+	for _, item := range items {
+		switch item.valueType {
+		case StringValue:
+			text.WriteString(item.text)
+		case Keyword:
+			text.WriteString(g.quote(item.keyword, node))
+		case FunctionValue:
+			switch item.function {
+			case "attr":
+				if len(item.args) > 0 {
+					text.WriteString(node.node.Parent.Attributes[item.args[0].keyword])
+				}
+			case "counter":
+				text.WriteString(g.counter(item.args, false))
+			case "counters":
+				text.WriteString(g.counter(item.args, true))
+			case "url":
+				flushText()
+				img := &Node{NodeType: ElementNode, Data: "img", Children: []*Node{}, Attributes: map[string]string{"src": item.args[0].text}, Parent: node.node}
+				node.children = append(node.children, &styledNode{node: img, specifiedValues: propertyMap{}, computedValues: inheritedValues(node.computedValues), children: []*styledNode{}})
+			}
+		}
+	}
+	flushText()
+}
+
+// counter formats counter(name, style) or counters(name, separator, style)
+func (g *contentGenerator) counter(args []Value, all bool) string {
+	if len(args) == 0 {
+		return ""
+	}
+	instances := g.counters[args[0].keyword]
+	if len(instances) == 0 {
+		instances = []int{0}
+	}
+	separator := ""
+	style := "decimal"
+	rest := args[1:]
+	if all && len(rest) > 0 {
+		separator = rest[0].text
+		rest = rest[1:]
+	}
+	if len(rest) > 0 {
+		style = rest[0].keyword
+	}
+	if !all {
+		return formatCounter(instances[len(instances)-1], style)
+	}
+	parts := []string{}
+	for _, n := range instances {
+		parts = append(parts, formatCounter(n, style))
+	}
+	return strings.Join(parts, separator)
+}
+
+// quote returns text for open-quote and close-quote and changes nesting depth
+func (g *contentGenerator) quote(keyword string, node *styledNode) string {
+	quotes := defaultQuotes
+	v := node.computedValues["quotes"]
+	if v.isKeyword("none") {
+		quotes = nil
+	} else if v.valueType == ListValue && len(v.list)%2 == 0 {
+		quotes = []string{}
+		for _, q := range v.list {
+			quotes = append(quotes, q.text)
+		}
+	}
+	pick := func(depth, offset int) string {
+		if len(quotes) == 0 {
+			return ""
+		}
+		if 2*depth >= len(quotes) {
+			depth = len(quotes)/2 - 1
+		}
+		return quotes[2*depth+offset]
+	}
+
+	switch keyword {
+	case "open-quote":
+		g.quoteDepth++
+		return pick(g.quoteDepth-1, 0)
+	case "no-open-quote":
+		g.quoteDepth++
+	case "close-quote":
+		if g.quoteDepth > 0 {
+			g.quoteDepth--
+			return pick(g.quoteDepth, 1)
+		}
+	case "no-close-quote":
+		if g.quoteDepth > 0 {
+			g.quoteDepth--
+		}
+	}
+	return ""
+}
+
+var romanNumerals = []struct {
+	value  int
+	symbol string
+}{
+	{1000, "m"}, {900, "cm"}, {500, "d"}, {400, "cd"}, {100, "c"}, {90, "xc"},
+	{50, "l"}, {40, "xl"}, {10, "x"}, {9, "ix"}, {5, "v"}, {4, "iv"}, {1, "i"},
+}
+
+// formatCounter represents value in counter style, https://www.w3.org/TR/css-counter-styles-3/
+func formatCounter(n int, style string) string {
+	switch style {
+	case "none":
+		return ""
+	case "disc":
+		return "•"
+	case "circle":
+		return "◦"
+	case "square":
+		return "▪"
+	case "decimal-leading-zero":
+		if n >= 0 && n < 10 {
+			return "0" + strconv.Itoa(n)
+		}
+	case "lower-roman", "upper-roman":
+		if n > 0 && n < 4000 {
+			s := new(strings.Builder)
+			for _, r := range romanNumerals {
+				for ; n >= r.value; n -= r.value {
+					s.WriteString(r.symbol)
+				}
+			}
+			if style == "upper-roman" {
+				return strings.ToUpper(s.String())
+			}
+			return s.String()
+		}
+	case "lower-alpha", "lower-latin", "upper-alpha", "upper-latin":
+		if n > 0 {
+			s := ""
+			for ; n > 0; n = (n - 1) / 26 {
+				s = string(rune('a'+(n-1)%26)) + s
+			}
+			if strings.HasPrefix(style, "upper") {
+				return strings.ToUpper(s)
+			}
+			return s
+		}
+	}
+	return strconv.Itoa(n)
+}
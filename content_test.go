@@ -0,0 +1,130 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func styleString(t *testing.T, html, css string) *styledNode {
+	node, err := parseHTMLWrapped(strings.NewReader(html))
+	if err != nil {
+		t.Fatal(err)
+	}
+	style, err := ParseStylesheet(strings.NewReader(css))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return styleTree(node, style)
+}
+
+func generatedText(n *styledNode) string {
+	s := new(strings.Builder)
+	for _, child := range n.children {
+		if child.node.NodeType == TextNode {
+			s.WriteString(child.node.Data)
+		}
+	}
+	return s.String()
+}
+
+func Test_generateContent(t *testing.T) {
+	t.Run("before and after are the first and the last children", func(t *testing.T) {
+		st := styleString(t, `<p title="x">text</p>`, `p::before {content: "[" attr(title) "]"} p:after {content: ")"}`)
+		p := st.children[0]
+		if len(p.children) != 3 {
+			t.Fatal("should be 3 children, got", len(p.children))
+		}
+		before, after := p.children[0], p.children[2]
+		if before.pseudo != "before" || before.node.TagName() != "::before" || generatedText(before) != "[x]" {
+			t.Error("wrong ::before", before.pseudo, generatedText(before))
+		}
+		if after.pseudo != "after" || generatedText(after) != ")" {
+			t.Error("wrong ::after", after.pseudo, generatedText(after))
+		}
+	})
+	t.Run("no box without content", func(t *testing.T) {
+		st := styleString(t, `<p>text</p>`, `p::before {color: #000000} p::after {content: none}`)
+		if len(st.children[0].children) != 1 {
+			t.Error("should be only text")
+		}
+	})
+	t.Run("numbered headings", func(t *testing.T) {
+		html := `<div><h1>A</h1><h2>A.1</h2><h2>A.2</h2><h1>B</h1><h2>B.1</h2></div>`
+		css := `div {counter-reset: chapter}
+			h1 {counter-increment: chapter; counter-reset: section}
+			h1::before {content: counter(chapter, upper-roman) ". "}
+			h2::before {counter-increment: section; content: counter(chapter) "." counter(section, lower-alpha) " "}`
+		st := styleString(t, html, css)
+		got := []string{}
+		for _, h := range st.children[0].children {
+			got = append(got, generatedText(h.children[0]))
+		}
+		want := []string{"I. ", "1.a ", "1.b ", "II. ", "2.a "}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%d: got %q, want %q", i, got[i], want[i])
+			}
+		}
+	})
+	t.Run("nested counters", func(t *testing.T) {
+		html := `<ol><li>1</li><li><ol><li>2.1</li><li>2.2</li></ol></li></ol>`
+		css := `ol {counter-reset: item} li {counter-increment: item} li::before {content: counters(item, ".") " "}`
+		st := styleString(t, html, css)
+		ol := st.children[0]
+		if got := generatedText(ol.children[1].children[0]); got != "2 " {
+			t.Error(got)
+		}
+		inner := ol.children[1].children[1]
+		if got := generatedText(inner.children[1].children[0]); got != "2.2 " {
+			t.Error(got)
+		}
+	})
+	t.Run("quotes", func(t *testing.T) {
+		html := `<q>a<q>b</q></q><blockquote>c</blockquote>`
+		css := `q::before {content: open-quote} q::after {content: close-quote}
+			blockquote {quotes: "<<" ">>"} blockquote::before {content: open-quote} blockquote::after {content: close-quote}`
+		st := styleString(t, html, css)
+		outer := st.children[0]
+		inner := outer.children[2]
+		if generatedText(outer.children[0]) != "“" || generatedText(inner.children[0]) != "‘" ||
+			generatedText(inner.children[2]) != "’" || generatedText(outer.children[3]) != "”" {
+			t.Error("wrong nested quotes")
+		}
+		bq := st.children[1]
+		if generatedText(bq.children[0]) != "<<" || generatedText(bq.children[2]) != ">>" {
+			t.Error("wrong custom quotes")
+		}
+	})
+	t.Run("url gives image", func(t *testing.T) {
+		st := styleString(t, `<a>x</a>`, `a::after {content: " " url(icon.png)}`)
+		after := st.children[0].children[1]
+		if len(after.children) != 2 || after.children[1].node.TagName() != "img" ||
+			after.children[1].node.Attributes["src"] != "icon.png" {
+			t.Error("image expected")
+		}
+	})
+}
+
+func Test_formatCounter(t *testing.T) {
+	tests := []struct {
+		n     int
+		style string
+		want  string
+	}{
+		{3, "decimal", "3"},
+		{-3, "decimal", "-3"},
+		{7, "decimal-leading-zero", "07"},
+		{1994, "upper-roman", "MCMXCIV"},
+		{4, "lower-roman", "iv"},
+		{0, "lower-roman", "0"},
+		{1, "lower-alpha", "a"},
+		{28, "upper-latin", "AB"},
+		{5, "disc", "•"},
+		{5, "none", ""},
+	}
+	for _, tt := range tests {
+		if got := formatCounter(tt.n, tt.style); got != tt.want {
+			t.Errorf("formatCounter(%d, %s) = %q, want %q", tt.n, tt.style, got, tt.want)
+		}
+	}
+}
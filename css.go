@@ -7,15 +7,14 @@ import (
 	"image/color"
 	"io"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"unicode"
 )
 
 // TODO:
-//	- Extend the CSS parser to discard any declaration that contains a parse error,
-//	and follow the error handling rules to resume parsing after the end of the declaration.
-//	(https://www.w3.org/TR/CSS2/syndata.html#parsing-errors)
 //	- Make the HTML parser pass the contents of any <style> nodes to the CSS parser,
 //	and return a Document object that includes a list of Stylesheets in addition to the DOM tree.
 
@@ -26,27 +25,99 @@ import (
 
 // Stylesheet represents a set of rules for style
 type Stylesheet struct {
-	rules []*Rule
+	rules  []*Rule
+	origin Origin
+	// href is where the sheet comes from, empty for embedded ones
+	href string
+	// imports are @import rules of the sheet, fontFaces are its @font-face rules
+	imports   []*importRule
+	fontFaces []*fontFace
+	// index is built on the first use, it must be reset when rules change.
+	// Sheets are shared by documents, which may be styled concurrently, so it is guarded by indexLock.
+	index     ruleIndex
+	indexLock sync.Mutex
 }
 
+// Origin tells who provided a stylesheet, https://www.w3.org/TR/css-cascade-4/#cascading-origins
+// Zero value is author, as it is the most common one.
+type Origin int
+
+// Cascade origins
+const (
+	AuthorOrigin Origin = iota
+	UserOrigin
+	UserAgentOrigin
+)
+
 // Rule contains a set of selectors and declarators
 type Rule struct {
 	selectors   []*Selector
 	declarators []*Declarator
+	// line is where the rule starts in its sheet, counting from 1
+	line int
+	conditions
+}
+
+// conditions are preludes of conditional rules around a rule, from the outermost,
+// the rule applies if all of them match
+type conditions struct {
+	// media are query lists of @media rules
+	media []mediaQueryList
+	// containers are queries of @container rules
+	containers []containerQuery
 }
 
-// Selector specifies which nodes are affected by rule
-// simple only - like tagName#id.class1.class2
+// Selector specifies which nodes are affected by rule.
+// It holds the rightmost compound selector, like tagName#id.class1.class2,
+// and the rest of complex selector is in left, joined by combinator:
+// for "nav > a" selector is "a", left is "nav" and combinator is child
 type Selector struct {
 	tagName *string
 	id      *string
 	class   []string
+	attrs   []attrSelector
+	pseudo  []pseudoClass
+	// pseudoElement is set only for the rightmost compound, like "before" for p::before
+	pseudoElement string
+
+	combinator combinator
+	left       *Selector
+}
+
+// attrSelector is like [name op "value" i]
+// op is empty if only presence of attribute is checked
+type attrSelector struct {
+	name            string
+	op              string
+	value           string
+	caseInsensitive bool
+	caseSensitive   bool
 }
 
+// pseudoClass is like :first-child, :nth-child(2n+1 of .x) or :not(p, div)
+// a and b are coefficients of an+b for :nth-* pseudo-classes,
+// selectors is the argument of :not(), :is(), :where() or "of S" of :nth-child()
+type pseudoClass struct {
+	name      string
+	a, b      int
+	selectors []*Selector
+}
+
+type combinator int
+
+// Combinators, https://www.w3.org/TR/selectors-4/#combinators
+const (
+	descendant combinator = iota
+	child
+	adjacentSibling
+	generalSibling
+)
+
 // Declarator describes specific style options
 type Declarator struct {
-	name  string
-	value Value
+	name      string
+	value     Value
+	important bool
 }
 
 type valueType int
@@ -55,68 +126,315 @@ const (
 	Keyword valueType = iota
 	Length
 	ColorValue
+	Number
+	StringValue
+	FunctionValue
+	ListValue
+	// Tokens is the raw text of a custom property
+	Tokens
+	// CustomProperties keeps computed custom properties of an element in vars
+	CustomProperties
+	// Pending is a longhand of a shorthand with var(), function is the shorthand
+	// and args has its value until var() is substituted
+	Pending
 )
 
 type UnitType int
 
 const (
 	Px UnitType = iota
+	Em
+	Rem
+	Ex
+	Ch
+	Vw
+	Vh
+	Vmin
+	Vmax
+	Percent
+	Pt
+	Pc
+	In
+	Cm
+	Mm
+	Q
 )
 
+var units = map[string]UnitType{
+	"px":   Px,
+	"em":   Em,
+	"rem":  Rem,
+	"ex":   Ex,
+	"ch":   Ch,
+	"vw":   Vw,
+	"vh":   Vh,
+	"vmin": Vmin,
+	"vmax": Vmax,
+	"%":    Percent,
+	"pt":   Pt,
+	"pc":   Pc,
+	"in":   In,
+	"cm":   Cm,
+	"mm":   Mm,
+	"q":    Q,
+}
+
+// pxPerUnit holds the ratio for absolute units, https://www.w3.org/TR/css-values-3/#absolute-lengths
+var pxPerUnit = map[UnitType]float32{
+	Px: 1,
+	Pt: 96.0 / 72.0,
+	Pc: 16,
+	In: 96,
+	Cm: 96 / 2.54,
+	Mm: 96 / 25.4,
+	Q:  96 / 101.6,
+}
+
+// Value is a single component, like keyword, length, color, string or function,
+// or a list of components separated by spaces, or by commas if commas is set
 type Value struct {
 	valueType valueType
 	keyword   string
 	length    float32
 	unitType  UnitType
 	color     color.RGBA
+	text      string
+	function  string
+	args      []Value
+	list      []Value
+	commas    bool
+	vars      propertyMap
+}
+
+func (v Value) isKeyword(keyword string) bool {
+	return v.valueType == Keyword && v.keyword == keyword
 }
 
+// toPx converts absolute lengths to pixels,
+// relative lengths are not known here and give zero
 func (v Value) toPx() float32 {
 	if v.valueType == Length {
-		return v.length
+		return v.length * pxPerUnit[v.unitType]
 	}
 	return 0.0
 }
 
-func (s *Selector) specificity() int {
-	result := 0
-	if s.id != nil {
-		result = 100
+// toPxOf is like toPx, but percentages are of the reference length
+func (v Value) toPxOf(reference float32) float32 {
+	if v.valueType == Length && v.unitType == Percent {
+		return v.length * reference / 100
 	}
-	result += len(s.class) * 10
-	if s.tagName != nil {
-		result++
+	return v.toPx()
+}
+
+// specificity is (a, b, c) tuple - counts of ids, classes and type selectors,
+// https://www.w3.org/TR/selectors-4/#specificity-rules
+type specificity [3]int
+
+func (s *Selector) specificity() specificity {
+	var result specificity
+	for ; s != nil; s = s.left {
+		if s.id != nil {
+			result[0]++
+		}
+		result[1] += len(s.class) + len(s.attrs)
+		if s.tagName != nil {
+			result[2]++
+		}
+		if s.pseudoElement != "" {
+			result[2]++
+		}
+		for _, p := range s.pseudo {
+			result = result.add(p.specificity())
+		}
 	}
 	return result
 }
 
-func compareSpecificity(sels1, sels2 []*Selector) int {
-	spec1 := 0
-	for _, s1 := range sels1 {
-		spec1 += s1.specificity()
+func (s specificity) add(other specificity) specificity {
+	for i := range s {
+		s[i] += other[i]
 	}
-	spec2 := 0
-	for _, s2 := range sels2 {
-		spec2 += s2.specificity()
+	return s
+}
+
+// specificity of pseudo-class is one class, except for
+// :is(), :not(), :has() and :nth-child(of S) which add the most specific argument,
+// and :where() which adds nothing
+func (p pseudoClass) specificity() specificity {
+	var result specificity
+	switch p.name {
+	case "where":
+		return result
+	case "is", "not", "has":
+	default:
+		result[1]++
+	}
+	var max specificity
+	for _, sel := range p.selectors {
+		if spec := sel.specificity(); compareSpecificity(spec, max) > 0 {
+			max = spec
+		}
+	}
+	return result.add(max)
+}
+
+// compareSpecificity returns negative, zero or positive number
+// if spec1 is less, equal or greater than spec2
+func compareSpecificity(spec1, spec2 specificity) int {
+	for i := range spec1 {
+		if spec1[i] != spec2[i] {
+			return spec1[i] - spec2[i]
+		}
 	}
-	return spec1 - spec2
+	return 0
 }
 
-// ParseStylesheet should parse CSS stylesheet
+// ParseStylesheet should parse CSS stylesheet of the author origin
 func ParseStylesheet(r io.Reader) (*Stylesheet, error) {
-	reader := bufio.NewReader(r)
+	return ParseStylesheetOrigin(r, AuthorOrigin)
+}
+
+// ParseStylesheetOrigin parses CSS stylesheet which comes from the given origin
+func ParseStylesheetOrigin(r io.Reader, origin Origin) (*Stylesheet, error) {
+	return parseStylesheet(r, origin, conditions{})
+}
+
+// parseStylesheet parses the sheet with its rules inside outer conditions, like media of @import
+func parseStylesheet(r io.Reader, origin Origin, outer conditions) (*Stylesheet, error) {
+	src := &lineCounter{r: r}
+	reader := bufio.NewReader(src)
+	sheet := &Stylesheet{origin: origin}
+	rules, err := parseRules(reader, src, sheet, outer)
+	if err != nil {
+		return nil, err
+	}
+	sheet.rules = rules
+	return sheet, nil
+}
+
+// parseRules parses rules up to the end of input or } of the enclosing block,
+// outer are conditions of the enclosing rules. @import and @font-face rules
+// go to the sheet, which is nil inside blocks.
+func parseRules(r *bufio.Reader, src *lineCounter, sheet *Stylesheet, outer conditions) ([]*Rule, error) {
 	rules := []*Rule{}
 	for {
-		rule, err := parseRule(reader)
+		skipSpaces(r)
+		line := src.line(r.Buffered())
+		if isNextChar(r, '@') {
+			nested, err := parseAtRule(r, src, line, sheet, outer, len(rules) == 0)
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, nested...)
+			continue
+		}
+		rule, err := parseRule(r)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("line %d: %w", line, err)
 		} else if rule == nil {
-			break
-		} else {
-			rules = append(rules, rule)
+			return rules, nil
 		}
+		rule.line = line
+		rule.conditions = outer
+		rules = append(rules, rule)
 	}
-	return &Stylesheet{rules}, nil
+}
+
+// parseAtRule parses @media and @container rules and returns rules inside them.
+// @import rules before style rules of the sheet and @font-face rules are added to the sheet,
+// other at-rules are skipped, as well as @container rules with malformed conditions.
+func parseAtRule(r *bufio.Reader, src *lineCounter, line int, sheet *Stylesheet, outer conditions, first bool) ([]*Rule, error) {
+	r.ReadRune()
+	name := strings.ToLower(readIdent(r))
+	inner := outer
+	switch {
+	case name == "import" && sheet != nil && first:
+		imp, err := parseImport(r)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		sheet.imports = append(sheet.imports, imp)
+		return nil, nil
+	case name == "font-face" && sheet != nil:
+		declarators, err := parseDeclarators(r)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		sheet.fontFaces = append(sheet.fontFaces, &fontFace{declarators: declarators})
+		return nil, nil
+	case name == "media":
+		list := parseMediaQueryList(r)
+		if !isNextChar(r, '{') {
+			skipAtRule(r)
+			return nil, nil
+		}
+		inner.media = append(outer.media[:len(outer.media):len(outer.media)], list)
+	case name == "container":
+		q, err := parseContainerQuery(r)
+		if err != nil {
+			// @container with malformed condition is ignored
+			skipAtRule(r)
+			return nil, nil
+		}
+		inner.containers = append(outer.containers[:len(outer.containers):len(outer.containers)], q)
+	default:
+		skipAtRule(r)
+		return nil, nil
+	}
+	r.ReadRune()
+	rules, err := parseRules(r, src, nil, inner)
+	if err != nil {
+		return nil, err
+	}
+	if !consumeRequired(r, '}') {
+		return nil, fmt.Errorf("line %d: } is required after @%s rules", src.line(r.Buffered()), name)
+	}
+	return rules, nil
+}
+
+// skipAtRule skips unknown at-rule up to ; or the end of its block
+func skipAtRule(r *bufio.Reader) {
+	depth := 0
+	for {
+		c, _, err := r.ReadRune()
+		if err != nil || c == ';' && depth == 0 {
+			return
+		}
+		if c == '{' {
+			depth++
+		} else if c == '}' {
+			depth--
+			if depth == 0 {
+				return
+			}
+		}
+	}
+}
+
+// lineCounter remembers where lines of the source start,
+// to know the line of what bufio.Reader has read from it
+type lineCounter struct {
+	r io.Reader
+	// read is count of bytes read from r
+	read     int
+	newlines []int
+}
+
+func (c *lineCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	for i, b := range p[:n] {
+		if b == '\n' {
+			c.newlines = append(c.newlines, c.read+i)
+		}
+	}
+	c.read += n
+	return n, err
+}
+
+// line returns line of the position, which is buffered bytes before the end of read data
+func (c *lineCounter) line(buffered int) int {
+	return sort.SearchInts(c.newlines, c.read-buffered) + 1
 }
 
 func parseRule(r *bufio.Reader) (*Rule, error) {
@@ -131,17 +449,17 @@ func parseRule(r *bufio.Reader) (*Rule, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Rule{selectors, declarators}, nil
+	return &Rule{selectors: selectors, declarators: declarators}, nil
 }
 
 func parseSelectors(r *bufio.Reader) ([]*Selector, error) {
 	selectors := []*Selector{}
 	for {
 		sel, err := parseSelector(r)
-		if sel == nil {
-			return selectors, nil
-		} else if err != nil {
+		if err != nil {
 			return nil, err
+		} else if sel == nil {
+			return selectors, nil
 		}
 		selectors = append(selectors, sel)
 		skipSpaces(r)
@@ -160,16 +478,66 @@ var nameChar = regexp.MustCompile("[_a-z0-9-]")
 // or nil as selector if parse selector is not possible
 // or returns an error
 func parseSelector(r *bufio.Reader) (*Selector, error) {
-	var selector *Selector
 	skipSpaces(r)
+	selector, err := parseCompoundSelector(r)
+	if selector == nil || err != nil {
+		return selector, err
+	}
+	for {
+		hadSpaces := isNextCharMatches(r, space)
+		skipSpaces(r)
+		comb := descendant
+		switch peekAndUnread(r) {
+		case '>':
+			comb = child
+		case '+':
+			comb = adjacentSibling
+		case '~':
+			comb = generalSibling
+		default:
+			if !hadSpaces {
+				return selector, nil
+			}
+		}
+		if comb != descendant {
+			r.ReadRune()
+			skipSpaces(r)
+		}
+		right, err := parseCompoundSelector(r)
+		if err != nil {
+			return selector, err
+		} else if right != nil && selector.pseudoElement != "" {
+			return selector, fmt.Errorf("pseudo-element must be at the end of selector")
+		} else if right == nil {
+			if comb != descendant {
+				return selector, fmt.Errorf("selector is expected after combinator")
+			}
+			return selector, nil
+		}
+		right.combinator = comb
+		right.left = selector
+		selector = right
+	}
+}
+
+var space = regexp.MustCompile(`\s`)
+
+// parseCompoundSelector parses sequence of simple selectors without combinators
+func parseCompoundSelector(r *bufio.Reader) (*Selector, error) {
+	var selector *Selector
 	for {
 		c, _, err := r.ReadRune()
 		if err == io.EOF {
 			return selector, nil
 		} else if err != nil {
 			return selector, err
+		} else if selector != nil && selector.pseudoElement != "" && c != ',' && c != '{' && !unicode.IsSpace(c) {
+			return selector, fmt.Errorf("pseudo-element must be at the end of selector")
 		} else if c == '*' {
 			// universal
+			if selector == nil {
+				selector = &Selector{}
+			}
 		} else if c == '#' {
 			name, err := readName(r)
 			if err != nil {
@@ -188,6 +556,31 @@ func parseSelector(r *bufio.Reader) (*Selector, error) {
 				selector = &Selector{}
 			}
 			selector.class = append(selector.class, name)
+		} else if c == '[' {
+			attr, err := readAttrSelector(r)
+			if err != nil {
+				return selector, err
+			}
+			if selector == nil {
+				selector = &Selector{}
+			}
+			selector.attrs = append(selector.attrs, attr)
+		} else if c == ':' {
+			if selector == nil {
+				selector = &Selector{}
+			}
+			element, err := readPseudoElement(r)
+			if err != nil {
+				return selector, err
+			} else if element != "" {
+				selector.pseudoElement = element
+				continue
+			}
+			pseudo, err := readPseudoClass(r)
+			if err != nil {
+				return selector, err
+			}
+			selector.pseudo = append(selector.pseudo, pseudo)
 		} else if nameStart.MatchString(string(c)) {
 			r.UnreadRune()
 			name, err := readName(r)
@@ -205,6 +598,269 @@ func parseSelector(r *bufio.Reader) (*Selector, error) {
 	}
 }
 
+// readAttrSelector parses attribute selector after [
+func readAttrSelector(r *bufio.Reader) (attrSelector, error) {
+	var attr attrSelector
+	skipSpaces(r)
+	name, err := readName(r)
+	if err != nil {
+		return attr, err
+	}
+	attr.name = name
+	skipSpaces(r)
+	if isNextChar(r, ']') {
+		r.ReadRune()
+		return attr, nil
+	}
+	c := getChar(r)
+	switch c {
+	case '=':
+		attr.op = "="
+	case '~', '|', '^', '$', '*':
+		if !consumeRequired(r, '=') {
+			return attr, fmt.Errorf("= is expected after %c in attribute selector", c)
+		}
+		attr.op = string(c) + "="
+	default:
+		return attr, fmt.Errorf("unexpected %q in attribute selector", c)
+	}
+	skipSpaces(r)
+	if isNextChar(r, '"') || isNextChar(r, '\'') {
+		attr.value, err = readString(r)
+	} else {
+		attr.value = readIdent(r)
+		if attr.value == "" {
+			err = fmt.Errorf("value is expected in attribute selector")
+		}
+	}
+	if err != nil {
+		return attr, err
+	}
+	skipSpaces(r)
+	if isNextCharMatches(r, unitChar) {
+		switch unicode.ToLower(getChar(r)) {
+		case 'i':
+			attr.caseInsensitive = true
+		case 's':
+			attr.caseSensitive = true
+		default:
+			return attr, fmt.Errorf("unknown attribute selector flag")
+		}
+		skipSpaces(r)
+	}
+	if !consumeRequired(r, ']') {
+		return attr, fmt.Errorf("] is required")
+	}
+	return attr, nil
+}
+
+var pseudoElements = map[string]bool{
+	"before": true, "after": true, "first-line": true, "first-letter": true,
+}
+
+// readPseudoElement parses pseudo-element after :,
+// which is either ::name, or :name for the ones from CSS2.
+// Empty name is returned if there is no pseudo-element.
+func readPseudoElement(r *bufio.Reader) (string, error) {
+	if isNextChar(r, ':') {
+		r.ReadRune()
+		name, err := readName(r)
+		if err != nil {
+			return "", err
+		} else if !pseudoElements[name] {
+			return "", fmt.Errorf("unsupported pseudo-element ::%s", name)
+		}
+		return name, nil
+	}
+	for _, name := range []string{"before", "after", "first-line", "first-letter"} {
+		b, _ := r.Peek(len(name) + 1)
+		if pseudoElements[name] && strings.HasPrefix(string(b), name) &&
+			(len(b) == len(name) || !nameChar.Match(b[len(name):])) {
+			r.Discard(len(name))
+			return name, nil
+		}
+	}
+	return "", nil
+}
+
+var structuralPseudoClasses = map[string]bool{
+	"root": true, "empty": true,
+	"first-child": true, "last-child": true, "only-child": true,
+	"first-of-type": true, "last-of-type": true, "only-of-type": true,
+}
+
+var functionalPseudoClasses = map[string]bool{
+	"nth-child": true, "nth-last-child": true,
+	"nth-of-type": true, "nth-last-of-type": true,
+	"not": true, "is": true, "where": true, "has": true,
+}
+
+var nthOf = regexp.MustCompile(`^(.*?)\sof\s(.*)$`)
+
+// readPseudoClass parses pseudo-class after :
+func readPseudoClass(r *bufio.Reader) (pseudoClass, error) {
+	var pseudo pseudoClass
+	name, err := readName(r)
+	if err != nil {
+		return pseudo, err
+	}
+	pseudo.name = name
+	if structuralPseudoClasses[name] {
+		return pseudo, nil
+	} else if !functionalPseudoClasses[name] {
+		return pseudo, fmt.Errorf("unsupported pseudo-class :%s", name)
+	} else if !consumeRequired(r, '(') {
+		return pseudo, fmt.Errorf("( is required after :%s", name)
+	}
+
+	switch name {
+	case "has":
+		pseudo.selectors, err = parseRelativeSelectors(r)
+		if err != nil {
+			return pseudo, err
+		}
+		if !consumeRequired(r, ')') {
+			return pseudo, fmt.Errorf(") is required")
+		}
+		return pseudo, nil
+	case "not", "is", "where":
+		pseudo.selectors, err = parseSelectors(r)
+		if err != nil {
+			return pseudo, err
+		} else if len(pseudo.selectors) == 0 {
+			return pseudo, fmt.Errorf("selector is expected in :%s()", name)
+		}
+		skipSpaces(r)
+		if !consumeRequired(r, ')') {
+			return pseudo, fmt.Errorf(") is required")
+		}
+		return pseudo, nil
+	}
+
+	arg := new(strings.Builder)
+	for {
+		c, _, err := r.ReadRune()
+		if err != nil {
+			return pseudo, fmt.Errorf(") is required")
+		} else if c == ')' {
+			break
+		}
+		arg.WriteRune(c)
+	}
+	nth := arg.String()
+	if m := nthOf.FindStringSubmatch(nth); m != nil && (name == "nth-child" || name == "nth-last-child") {
+		nth = m[1]
+		sr := bufio.NewReader(strings.NewReader(m[2]))
+		pseudo.selectors, err = parseSelectors(sr)
+		skipSpaces(sr)
+		if err != nil {
+			return pseudo, err
+		} else if _, _, eof := sr.ReadRune(); eof == nil || len(pseudo.selectors) == 0 {
+			return pseudo, fmt.Errorf("wrong selector in :%s(of S)", name)
+		}
+	}
+	pseudo.a, pseudo.b, err = parseAnPlusB(nth)
+	return pseudo, err
+}
+
+// parseRelativeSelectors parses list of selectors which may start with combinator,
+// like "> img, + .x". The leftmost compound of each selector has the combinator
+// which relates it to the anchor element, descendant if omitted
+func parseRelativeSelectors(r *bufio.Reader) ([]*Selector, error) {
+	selectors := []*Selector{}
+	for {
+		skipSpaces(r)
+		comb := descendant
+		switch peekAndUnread(r) {
+		case '>':
+			comb = child
+		case '+':
+			comb = adjacentSibling
+		case '~':
+			comb = generalSibling
+		}
+		if comb != descendant {
+			r.ReadRune()
+		}
+		sel, err := parseSelector(r)
+		if err != nil {
+			return nil, err
+		} else if sel == nil {
+			return nil, fmt.Errorf("relative selector is expected")
+		}
+		leftmost := sel
+		for leftmost.left != nil {
+			leftmost = leftmost.left
+		}
+		leftmost.combinator = comb
+		selectors = append(selectors, sel)
+		skipSpaces(r)
+		if !isNextChar(r, ',') {
+			return selectors, nil
+		}
+		r.ReadRune()
+	}
+}
+
+var anPlusB = regexp.MustCompile(`^([-+]?\d*)n\s*(?:([-+])\s*(\d+))?$`)
+var integer = regexp.MustCompile(`^[-+]?\d+$`)
+
+// parseAnPlusB parses an+b microsyntax, https://www.w3.org/TR/css-syntax-3/#anb-microsyntax
+func parseAnPlusB(s string) (a, b int, err error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch {
+	case s == "odd":
+		return 2, 1, nil
+	case s == "even":
+		return 2, 0, nil
+	case integer.MatchString(s):
+		b, err = strconv.Atoi(s)
+		return 0, b, err
+	}
+	m := anPlusB.FindStringSubmatch(s)
+	if m == nil {
+		return 0, 0, fmt.Errorf("wrong an+b %q", s)
+	}
+	switch m[1] {
+	case "", "+":
+		a = 1
+	case "-":
+		a = -1
+	default:
+		a, _ = strconv.Atoi(m[1])
+	}
+	if m[3] != "" {
+		b, _ = strconv.Atoi(m[3])
+		if m[2] == "-" {
+			b = -b
+		}
+	}
+	return a, b, nil
+}
+
+// readString parses string in single or double quotes, with escaped characters
+func readString(r *bufio.Reader) (string, error) {
+	quote := getChar(r)
+	s := new(strings.Builder)
+	for {
+		c, _, err := r.ReadRune()
+		if err != nil {
+			return "", fmt.Errorf("unclosed string")
+		} else if c == quote {
+			return s.String(), nil
+		} else if c == '\\' {
+			c, _, err = r.ReadRune()
+			if err != nil {
+				return "", fmt.Errorf("unclosed string")
+			}
+		}
+		s.WriteRune(c)
+	}
+}
+
+// parseDeclarators parses a block of declarations. A declaration with parse error
+// is dropped up to its end and parsing goes on after it,
+// https://www.w3.org/TR/CSS2/syndata.html#parsing-errors
 func parseDeclarators(r *bufio.Reader) ([]*Declarator, error) {
 	skipSpaces(r)
 	declarators := []*Declarator{}
@@ -216,13 +872,18 @@ func parseDeclarators(r *bufio.Reader) ([]*Declarator, error) {
 			r.ReadRune()
 		}
 		d, err := parseDeclarator(r)
+		if err == nil && d != nil && !isNextChar(r, ';') && !isNextChar(r, '}') && !isNextCharEOF(r) {
+			err = fmt.Errorf("; is expected after declaration %s", d.name)
+		} else if err == nil && d == nil && !isNextChar(r, '}') && !isNextCharEOF(r) {
+			err = fmt.Errorf("declaration is expected")
+		}
 		if err != nil {
-			return nil, err
+			skipDeclaration(r)
+			continue
 		} else if d == nil {
 			break
-		} else {
-			declarators = append(declarators, d)
 		}
+		declarators = append(declarators, d)
 	}
 	if !consumeRequired(r, '}') {
 		return declarators, fmt.Errorf("} is required")
@@ -230,71 +891,366 @@ func parseDeclarators(r *bufio.Reader) ([]*Declarator, error) {
 	return declarators, nil
 }
 
+// parseDeclarationList parses declarations without braces, like in style attribute.
+// A declaration with parse error is skipped up to the next semicolon,
+// the first error is returned along with the rest of declarations.
+func parseDeclarationList(r *bufio.Reader) ([]*Declarator, error) {
+	declarators := []*Declarator{}
+	var firstErr error
+	for {
+		skipSpaces(r)
+		for isNextChar(r, ';') {
+			r.ReadRune()
+			skipSpaces(r)
+		}
+		if _, _, err := r.ReadRune(); err == io.EOF {
+			return declarators, firstErr
+		}
+		r.UnreadRune()
+		d, err := parseDeclarator(r)
+		if err == nil && d != nil && !isNextChar(r, ';') && !isNextCharEOF(r) {
+			err = fmt.Errorf("; is expected after declaration %s", d.name)
+		} else if err == nil && d == nil {
+			err = fmt.Errorf("declaration is expected")
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			skipUntil(r, ';')
+			continue
+		}
+		declarators = append(declarators, d)
+	}
+}
+
+func isNextCharEOF(r *bufio.Reader) bool {
+	_, err := r.Peek(1)
+	return err == io.EOF
+}
+
+// skipDeclaration skips the rest of invalid declaration up to ; or } out of blocks and strings,
+// which are left to read. Closing brackets without their opening ones are skipped,
+// as those may be read before the error.
+func skipDeclaration(r *bufio.Reader) {
+	closing := []rune{}
+	for {
+		c, _, err := r.ReadRune()
+		if err != nil {
+			return
+		}
+		if len(closing) == 0 && (c == ';' || c == '}') {
+			r.UnreadRune()
+			return
+		}
+		switch c {
+		case '"', '\'':
+			r.UnreadRune()
+			if _, err := readString(r); err != nil {
+				return
+			}
+		case '(':
+			closing = append(closing, ')')
+		case '[':
+			closing = append(closing, ']')
+		case '{':
+			closing = append(closing, '}')
+		case ')', ']', '}':
+			if len(closing) > 0 && closing[len(closing)-1] == c {
+				closing = closing[:len(closing)-1]
+			}
+		}
+	}
+}
+
+// skipUntil skips characters up to and including c
+func skipUntil(r *bufio.Reader, c rune) {
+	for {
+		x, _, err := r.ReadRune()
+		if err != nil || x == c {
+			return
+		}
+	}
+}
+
+// caseSensitiveProperties have names among their keywords, like font families and counters,
+// only CSS-wide keywords of them are lowercased
+var caseSensitiveProperties = map[string]bool{
+	"font": true, "font-family": true, "content": true, "quotes": true,
+	"counter-reset": true, "counter-increment": true, "counter-set": true,
+	"container": true, "container-name": true, "animation": true, "animation-name": true,
+}
+
+var cssWideKeywords = map[string]bool{"inherit": true, "initial": true, "unset": true, "revert": true}
+
+// normalizeValue lowercases keywords of the property value, which are ASCII case-insensitive,
+// and parses named colors of properties, which take colors
+func normalizeValue(name string, v Value) Value {
+	if v.valueType == Keyword && cssWideKeywords[strings.ToLower(v.keyword)] {
+		v.keyword = strings.ToLower(v.keyword)
+		return v
+	}
+	if !caseSensitiveProperties[name] {
+		v = lowercaseKeywords(v)
+	}
+	if colorProperties[name] {
+		v = withNamedColors(v)
+	}
+	return v
+}
+
+// lowercaseKeywords lowercases keywords of the value, those in functions
+// are left, as var() refers to case-sensitive custom properties
+func lowercaseKeywords(v Value) Value {
+	switch v.valueType {
+	case Keyword:
+		v.keyword = strings.ToLower(v.keyword)
+	case ListValue:
+		list := make([]Value, len(v.list))
+		for i, item := range v.list {
+			list[i] = lowercaseKeywords(item)
+		}
+		v.list = list
+	}
+	return v
+}
+
+// propertyStart is the first character of property names, which are case-insensitive
+var propertyStart = regexp.MustCompile("[_a-zA-Z]")
+
 func parseDeclarator(r *bufio.Reader) (*Declarator, error) {
 	skipSpaces(r)
-	if !isNextCharMatches(r, nameStart) {
+	custom := isNextCustomProperty(r)
+	if !custom && !isNextCharMatches(r, propertyStart) {
 		return nil, nil
 	}
-	name, err := readName(r)
-	if err != nil {
-		return nil, err
+	var err error
+	name := readIdent(r)
+	if !custom {
+		name = strings.ToLower(name)
 	}
 	declarator := new(Declarator)
 	declarator.name = name
+	skipSpaces(r)
 	if !isNextChar(r, ':') {
 		return nil, fmt.Errorf("NEXT CHAR SHOULD BE COLON :")
 	}
 	r.ReadRune()
+	if custom {
+		declarator.value, err = readTokens(r)
+	} else {
+		declarator.value, err = parseValue(r)
+		declarator.value = normalizeValue(name, declarator.value)
+	}
+	if err != nil {
+		return nil, err
+	}
 	skipSpaces(r)
-	if isNextCharMatches(r, nameStart) {
-		// keyword
-		keyword, err := readName(r)
-		if err != nil {
+	if isNextChar(r, '!') {
+		r.ReadRune()
+		skipSpaces(r)
+		matches, err := matchStringInsens(r, "important")
+		if err != nil && err != io.EOF {
 			return nil, err
+		} else if !matches {
+			return nil, fmt.Errorf("important is expected after !")
 		}
-		declarator.value.valueType = Keyword
-		declarator.value.keyword = keyword
-	} else if isNextChar(r, '#') {
-		// color
-		color, err := readColor(r)
-		if err != nil {
+		declarator.important = true
+		skipSpaces(r)
+	}
+	return declarator, nil
+}
+
+// parseValue parses value of declaration: a single component,
+// or space separated list of components, or comma separated list of those
+func parseValue(r *bufio.Reader) (Value, error) {
+	groups, err := parseComponents(r)
+	if err != nil {
+		return Value{}, err
+	} else if len(groups) == 1 {
+		return groups[0], nil
+	}
+	return Value{valueType: ListValue, list: groups, commas: true}, nil
+}
+
+// parseComponents parses comma separated groups of components
+// until the end of declaration or closing parenthesis
+func parseComponents(r *bufio.Reader) ([]Value, error) {
+	groups := []Value{}
+	components := []Value{}
+	for {
+		skipSpaces(r)
+		c, _, err := r.ReadRune()
+		if err == nil {
+			r.UnreadRune()
+		}
+		if err == io.EOF || c == ';' || c == '}' || c == '!' || c == ')' || c == ',' {
+			if len(components) == 0 {
+				return nil, fmt.Errorf("value is expected")
+			} else if len(components) == 1 {
+				groups = append(groups, components[0])
+			} else {
+				groups = append(groups, Value{valueType: ListValue, list: components})
+			}
+			if c != ',' || err == io.EOF {
+				return groups, nil
+			}
+			r.ReadRune()
+			components = []Value{}
+			continue
+		} else if err != nil {
 			return nil, err
 		}
-		declarator.value.valueType = ColorValue
-		declarator.value.color = color
-	} else {
-		// length
-		length, err := readLength(r)
+		component, err := parseComponent(r)
 		if err != nil {
 			return nil, err
 		}
-		declarator.value.valueType = Length
-		declarator.value.unitType = Px
-		declarator.value.length = float32(length)
+		components = append(components, component)
 	}
-	return declarator, nil
 }
 
-func readLength(r *bufio.Reader) (float64, error) {
-	s := ""
+var identStart = regexp.MustCompile("[-_a-zA-Z]")
+
+func parseComponent(r *bufio.Reader) (Value, error) {
+	var value Value
+	switch {
+	case isNextChar(r, '"') || isNextChar(r, '\''):
+		s, err := readString(r)
+		value.valueType = StringValue
+		value.text = s
+		return value, err
+	case isNextChar(r, '#'):
+		color, err := readColor(r)
+		value.valueType = ColorValue
+		value.color = color
+		return value, err
+	case startsNumber(r):
+		// number, length or percentage
+		return readLength(r)
+	case isNextCharMatches(r, identStart):
+		name := readIdent(r)
+		if !isNextChar(r, '(') {
+			value.valueType = Keyword
+			value.keyword = name
+			return value, nil
+		}
+		r.ReadRune()
+		value.valueType = FunctionValue
+		value.function = strings.ToLower(name)
+		if value.function == "url" && !isNextChar(r, '"') && !isNextChar(r, '\'') {
+			url, err := readURL(r)
+			value.args = []Value{{valueType: StringValue, text: url}}
+			return value, err
+		}
+		args, err := parseComponents(r)
+		if err != nil {
+			return value, err
+		} else if !consumeRequired(r, ')') {
+			return value, fmt.Errorf(") is required after arguments of %s", name)
+		}
+		value.args = args
+		return value, nil
+	}
+	return value, fmt.Errorf("unexpected %q in value", peekAndUnread(r))
+}
+
+// readURL reads unquoted url till the closing parenthesis
+func readURL(r *bufio.Reader) (string, error) {
+	s := new(strings.Builder)
 	for {
 		c, _, err := r.ReadRune()
 		if err != nil {
-			return 0.0, err
-		} else if unicode.IsNumber(c) {
-			s += string(c)
-		} else {
-			r.UnreadRune()
-			break
+			return "", fmt.Errorf(") is required after url")
+		} else if c == ')' {
+			return strings.TrimSpace(s.String()), nil
 		}
+		s.WriteRune(c)
 	}
-	matches, err := matchStringInsens(r, "px")
+}
+
+// readLength parses a number followed by an optional unit.
+// Number without unit is allowed only for zero, which is a length,
+// other unitless numbers give Number value
+func readLength(r *bufio.Reader) (Value, error) {
+	var value Value
+	n, err := readNumber(r)
 	if err != nil {
-		return 0.0, err
-	} else if !matches {
-		return 0.0, fmt.Errorf("px is expected")
+		return value, err
+	}
+	value.length = float32(n)
+	if isNextChar(r, '%') {
+		r.ReadRune()
+		value.valueType = Length
+		value.unitType = Percent
+		return value, nil
+	}
+	if !isNextCharMatches(r, unitChar) {
+		value.valueType = Number
+		return value, nil
+	}
+	unit := new(strings.Builder)
+	for isNextCharMatches(r, unitChar) {
+		unit.WriteRune(unicode.ToLower(getChar(r)))
+	}
+	unitType, ok := units[unit.String()]
+	if !ok {
+		return value, fmt.Errorf("unknown unit %q", unit.String())
+	}
+	value.valueType = Length
+	value.unitType = unitType
+	return value, nil
+}
+
+var unitChar = regexp.MustCompile("[a-zA-Z]")
+var digit = regexp.MustCompile("[0-9]")
+
+// readNumber parses number as described in https://www.w3.org/TR/css-syntax-3/#consume-number
+func readNumber(r *bufio.Reader) (float64, error) {
+	if !startsNumber(r) {
+		return 0.0, fmt.Errorf("number is expected")
+	}
+	s := new(strings.Builder)
+	if isNextChar(r, '+') || isNextChar(r, '-') {
+		s.WriteRune(getChar(r))
+	}
+	readDigits(r, s)
+	if b, _ := r.Peek(2); len(b) == 2 && b[0] == '.' && isDigit(b[1]) {
+		s.WriteRune(getChar(r))
+		readDigits(r, s)
+	}
+	b, _ := r.Peek(3)
+	if len(b) >= 2 && (b[0] == 'e' || b[0] == 'E') &&
+		(isDigit(b[1]) || len(b) == 3 && (b[1] == '+' || b[1] == '-') && isDigit(b[2])) {
+		s.WriteRune(getChar(r))
+		s.WriteRune(getChar(r))
+		readDigits(r, s)
+	}
+	return strconv.ParseFloat(s.String(), 32)
+}
+
+// startsNumber checks if next three characters would start a number
+func startsNumber(r *bufio.Reader) bool {
+	b, _ := r.Peek(3)
+	if len(b) == 0 {
+		return false
+	}
+	if b[0] == '+' || b[0] == '-' {
+		b = b[1:]
+	}
+	if len(b) > 0 && isDigit(b[0]) {
+		return true
 	}
-	return strconv.ParseFloat(s, 32)
+	return len(b) > 1 && b[0] == '.' && isDigit(b[1])
+}
+
+func readDigits(r *bufio.Reader, s *strings.Builder) {
+	for isNextCharMatches(r, digit) {
+		s.WriteRune(getChar(r))
+	}
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
 }
 
 func matchStringInsens(r *bufio.Reader, s string) (bool, error) {
@@ -323,8 +1279,12 @@ func readColor(r *bufio.Reader) (color.RGBA, error) {
 			break
 		}
 	}
+	if len(s) == 3 {
+		// #rgb is short for #rrggbb
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]})
+	}
 	if len(s) != 6 {
-		return col, fmt.Errorf("color must have six digits")
+		return col, fmt.Errorf("color must have three or six digits")
 	}
 	b, err := hex.DecodeString(s)
 	if err != nil {
@@ -368,6 +1328,17 @@ func readName(r *bufio.Reader) (string, error) {
 	return s.String(), nil
 }
 
+var identChar = regexp.MustCompile("[-_a-zA-Z0-9]")
+
+// readIdent reads identifier which case is preserved, empty if there is none
+func readIdent(r *bufio.Reader) string {
+	s := new(strings.Builder)
+	for isNextCharMatches(r, identChar) {
+		s.WriteRune(getChar(r))
+	}
+	return s.String()
+}
+
 func skipSpaces(r *bufio.Reader) error {
 	for {
 		b, _, err := r.ReadRune()
@@ -65,6 +65,91 @@ func Test_parseSelector(t *testing.T) {
 			t.Errorf("%v ::: %v", got, err)
 		}
 	})
+	t.Run("", func(t *testing.T) {
+		r := mr(".card > .title {")
+		got, err := parseSelector(r)
+
+		if err != nil || got == nil || got.class[0] != "title" || got.combinator != child ||
+			got.left == nil || got.left.class[0] != "card" || got.left.left != nil || !isNextChar(r, '{') {
+			t.Error(got, err)
+		}
+	})
+	t.Run("", func(t *testing.T) {
+		r := mr("nav a+b~i")
+		got, err := parseSelector(r)
+
+		if err != nil || got == nil || *got.tagName != "i" || got.combinator != generalSibling ||
+			*got.left.tagName != "b" || got.left.combinator != adjacentSibling ||
+			*got.left.left.tagName != "a" || got.left.left.combinator != descendant ||
+			*got.left.left.left.tagName != "nav" {
+			t.Error(got, err)
+		}
+	})
+	t.Run("", func(t *testing.T) {
+		r := mr("* > *")
+		got, err := parseSelector(r)
+
+		if err != nil || got == nil || got.left == nil || got.combinator != child {
+			t.Error(got, err)
+		}
+	})
+	t.Run("", func(t *testing.T) {
+		r := mr(`a[href][ type = "text" i][lang|=en][rel~='next' s]`)
+		got, err := parseSelector(r)
+
+		if err != nil || got == nil || len(got.attrs) != 4 ||
+			got.attrs[0] != (attrSelector{name: "href"}) ||
+			got.attrs[1] != (attrSelector{name: "type", op: "=", value: "text", caseInsensitive: true}) ||
+			got.attrs[2] != (attrSelector{name: "lang", op: "|=", value: "en"}) ||
+			got.attrs[3] != (attrSelector{name: "rel", op: "~=", value: "next", caseSensitive: true}) {
+			t.Error(got, err)
+		}
+	})
+	t.Run("", func(t *testing.T) {
+		for _, s := range []string{"[a=", "[a!=b]", "[a=b x]", `[a="b]`} {
+			if _, err := parseSelectors(mr(s)); err == nil {
+				t.Error("error expected for", s)
+			}
+		}
+	})
+	t.Run("", func(t *testing.T) {
+		r := mr("li:nth-child( 2n+1 of .x, .y ):not(.z) {")
+		got, err := parseSelector(r)
+
+		if err != nil || got == nil || len(got.pseudo) != 2 ||
+			got.pseudo[0].name != "nth-child" || got.pseudo[0].a != 2 || got.pseudo[0].b != 1 ||
+			len(got.pseudo[0].selectors) != 2 || got.pseudo[1].name != "not" ||
+			len(got.pseudo[1].selectors) != 1 || !isNextChar(r, '{') {
+			t.Error(got, err)
+		}
+	})
+	t.Run("", func(t *testing.T) {
+		for _, s := range []string{":hover", ":not()", ":has()", ":has(>)", ":not(a", ":nth-child(x)", ":nth-of-type(1 of p)"} {
+			if _, err := parseSelectors(mr(s)); err == nil {
+				t.Error("error expected for", s)
+			}
+		}
+	})
+	t.Run("", func(t *testing.T) {
+		for _, s := range []string{"p::before", "p:before", "p:not(.a)::after"} {
+			got, err := parseSelector(mr(s))
+			if err != nil || got == nil || got.pseudoElement == "" {
+				t.Error(s, got, err)
+			}
+		}
+		for _, s := range []string{"p::before.a", "p::before a", "p::marker", "p:beforex"} {
+			if _, err := parseSelectors(mr(s)); err == nil {
+				t.Error("error expected for", s)
+			}
+		}
+	})
+	t.Run("", func(t *testing.T) {
+		_, err := parseSelector(mr("a > {"))
+
+		if err == nil {
+			t.Error("error expected")
+		}
+	})
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			got, err := parseSelector(tt.args.r)
@@ -185,6 +270,30 @@ func Test_parseDeclarator(t *testing.T) {
 			t.Error(got, err)
 		}
 	})
+	t.Run("", func(t *testing.T) {
+		r := mr("margin-left: -10px")
+		got, err := parseDeclarator(r)
+
+		if !(err == nil && got != nil && got.value.length == -10 && got.value.valueType == Length) {
+			t.Error(got, err)
+		}
+	})
+	t.Run("", func(t *testing.T) {
+		r := mr("a: b !important ;")
+		got, err := parseDeclarator(r)
+
+		if !(err == nil && got != nil && got.value.keyword == "b" && got.important && isNextChar(r, ';')) {
+			t.Error(got, err)
+		}
+	})
+	t.Run("", func(t *testing.T) {
+		r := mr("a: b !imp")
+		_, err := parseDeclarator(r)
+
+		if err == nil {
+			t.Error("error expected")
+		}
+	})
 	t.Run("", func(t *testing.T) {
 		r := mr("a: #010203")
 		got, err := parseDeclarator(r)
@@ -200,6 +309,135 @@ func Test_parseDeclarator(t *testing.T) {
 	})
 }
 
+func Test_parseValue(t *testing.T) {
+	t.Run("", func(t *testing.T) {
+		got, err := parseValue(mr(`"Chapter " counter(chapter, upper-roman) '. ' ;`))
+		if err != nil || got.valueType != ListValue || len(got.list) != 3 || got.commas ||
+			got.list[0].text != "Chapter " || got.list[1].function != "counter" ||
+			len(got.list[1].args) != 2 || got.list[1].args[1].keyword != "upper-roman" || got.list[2].text != ". " {
+			t.Error(got, err)
+		}
+	})
+	t.Run("", func(t *testing.T) {
+		got, err := parseValue(mr(`Georgia, "Times New Roman", serif}`))
+		if err != nil || got.valueType != ListValue || !got.commas || len(got.list) != 3 || got.list[0].keyword != "Georgia" {
+			t.Error(got, err)
+		}
+	})
+	t.Run("", func(t *testing.T) {
+		got, err := parseValue(mr(`url(images/a b.png) no-repeat`))
+		if err != nil || got.valueType != ListValue || got.list[0].function != "url" || got.list[0].args[0].text != "images/a b.png" {
+			t.Error(got, err)
+		}
+	})
+	t.Run("", func(t *testing.T) {
+		got, err := parseValue(mr(`0 -1.5em`))
+		if err != nil || got.valueType != ListValue || got.list[1].length != -1.5 || got.list[1].unitType != Em {
+			t.Error(got, err)
+		}
+	})
+	t.Run("", func(t *testing.T) {
+		for _, s := range []string{"", ";", "counter(a", "url(a", `"a`, "@"} {
+			if _, err := parseValue(mr(s)); err == nil {
+				t.Error("error expected for", s)
+			}
+		}
+	})
+}
+
+func Test_readLength(t *testing.T) {
+	tests := []struct {
+		in       string
+		want     float32
+		wantType valueType
+		wantUnit UnitType
+	}{
+		{"10px", 10, Length, Px},
+		{"1.5em", 1.5, Length, Em},
+		{"-10px", -10, Length, Px},
+		{"+2px", 2, Length, Px},
+		{".5px", 0.5, Length, Px},
+		{"1e3px", 1000, Length, Px},
+		{"1E-1px", 0.1, Length, Px},
+		{"50%", 50, Length, Percent},
+		{"12PT", 12, Length, Pt},
+		{"0", 0, Number, Px},
+		{"1.25", 1.25, Number, Px},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := readLength(mr(tt.in))
+			if err != nil || got.length != tt.want || got.valueType != tt.wantType || got.unitType != tt.wantUnit {
+				t.Error(got, err)
+			}
+		})
+	}
+	t.Run("unknown unit", func(t *testing.T) {
+		_, err := readLength(mr("10zz"))
+		if err == nil {
+			t.Error("error expected")
+		}
+	})
+	t.Run("not a number", func(t *testing.T) {
+		_, err := readLength(mr("-x"))
+		if err == nil {
+			t.Error("error expected")
+		}
+	})
+	t.Run("unit rest is kept", func(t *testing.T) {
+		r := mr("1em;")
+		got, err := readLength(r)
+		if err != nil || got.unitType != Em || !isNextChar(r, ';') {
+			t.Error(got, err)
+		}
+	})
+}
+
+func Test_toPx(t *testing.T) {
+	t.Run("", func(t *testing.T) {
+		if v := (Value{valueType: Length, length: 12, unitType: Pt}); v.toPx() != 16 {
+			t.Error(v.toPx())
+		}
+		if v := (Value{valueType: Length, length: 1, unitType: In}); v.toPx() != 96 {
+			t.Error(v.toPx())
+		}
+	})
+}
+
+func Test_parseAnPlusB(t *testing.T) {
+	tests := []struct {
+		in   string
+		a, b int
+	}{
+		{"odd", 2, 1},
+		{"EVEN", 2, 0},
+		{"3", 0, 3},
+		{"-1", 0, -1},
+		{"n", 1, 0},
+		{"-n+3", -1, 3},
+		{"+n", 1, 0},
+		{"2n+1", 2, 1},
+		{" 2n + 1 ", 2, 1},
+		{"10n-9", 10, -9},
+		{"-2n", -2, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			a, b, err := parseAnPlusB(tt.in)
+			if err != nil || a != tt.a || b != tt.b {
+				t.Error(a, b, err)
+			}
+		})
+	}
+	t.Run("", func(t *testing.T) {
+		for _, s := range []string{"", "n2", "2x+1", "2n+", "+-n"} {
+			if _, _, err := parseAnPlusB(s); err == nil {
+				t.Error("error expected for", s)
+			}
+		}
+	})
+}
+
 func Test_parseDeclarators(t *testing.T) {
 	type args struct {
 		r *bufio.Reader
@@ -258,6 +496,33 @@ func Test_parseDeclarators(t *testing.T) {
 	})
 }
 
+func Test_parseDeclarationList(t *testing.T) {
+	t.Run("", func(t *testing.T) {
+		got, err := parseDeclarationList(mr(" color: #ff0000; margin : 4px ;; "))
+		if err != nil || len(got) != 2 || got[1].name != "margin" || got[1].value.length != 4 {
+			t.Error(got, err)
+		}
+	})
+	t.Run("", func(t *testing.T) {
+		got, err := parseDeclarationList(mr(""))
+		if err != nil || len(got) != 0 {
+			t.Error(got, err)
+		}
+	})
+	t.Run("invalid declarations are skipped", func(t *testing.T) {
+		got, err := parseDeclarationList(mr("color: @; margin: 1px 2px x; padding: 2px !important"))
+		if err == nil || len(got) != 2 || got[0].name != "margin" || got[1].name != "padding" || !got[1].important {
+			t.Error(got, err)
+		}
+	})
+	t.Run("property names are lowercased", func(t *testing.T) {
+		got, err := parseDeclarationList(mr("COLOR: red; Width: 1px; --Main-Color: Red"))
+		if err != nil || len(got) != 3 || got[0].name != "color" || got[1].name != "width" || got[2].name != "--Main-Color" {
+			t.Error(got, err)
+		}
+	})
+}
+
 func TestParseStylesheet(t *testing.T) {
 	type args struct {
 		r io.Reader
@@ -300,13 +565,27 @@ func TestParseStylesheet(t *testing.T) {
 			t.Error(got, err)
 		}
 	})
-
+	t.Run("invalid declarations are dropped", func(t *testing.T) {
+		got, err := ParseStylesheet(mr(`p { width: calc(var(--gap) * 2); color: #ff0000 }
+		a { margin: 1px @ 2px; content: "x; }"; padding: 2px }
+		b { color: #00ff00; 1px: 2px; width: (1px; }) 2px; margin: 3px }
+		h1 { display: none }`))
+		if err != nil || len(got.rules) != 4 {
+			t.Fatal(got, err)
+		}
+		want := []string{"p { color: #ff0000; }", `a { content: "x; }"; padding: 2px; }`, "b { color: #00ff00; margin: 3px; }", "h1 { display: none; }"}
+		for i, rule := range got.rules {
+			if rule.String() != want[i] {
+				t.Errorf("got %q, want %q", rule.String(), want[i])
+			}
+		}
+	})
 }
 
 func Test_compareSpecificity(t *testing.T) {
 	type args struct {
-		sels1 []*Selector
-		sels2 []*Selector
+		spec1 specificity
+		spec2 specificity
 	}
 	tests := []struct {
 		name string
@@ -317,7 +596,7 @@ func Test_compareSpecificity(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := compareSpecificity(tt.args.sels1, tt.args.sels2); got != tt.want {
+			if got := compareSpecificity(tt.args.spec1, tt.args.spec2); got != tt.want {
 				t.Errorf("compareSpecificity() = %v, want %v", got, tt.want)
 			}
 		})
@@ -327,8 +606,59 @@ func Test_compareSpecificity(t *testing.T) {
 		ss2, _ := ParseStylesheet(mr(`p, .two, .three {}`))
 		fmt.Println(ss1)
 
-		if !(compareSpecificity(ss1.rules[0].selectors, ss2.rules[0].selectors) == 0) {
+		if !(compareSpecificity(ss1.rules[0].selectors[1].specificity(), ss2.rules[0].selectors[1].specificity()) == 0) {
 			t.Error(ss1, ss2)
 		}
 	})
+	t.Run("group of type selectors is less than class", func(t *testing.T) {
+		ss1, _ := ParseStylesheet(mr(`h1, h2, h3 {}`))
+		ss2, _ := ParseStylesheet(mr(`.note {}`))
+
+		for _, sel := range ss1.rules[0].selectors {
+			if !(compareSpecificity(sel.specificity(), ss2.rules[0].selectors[0].specificity()) < 0) {
+				t.Error(sel)
+			}
+		}
+	})
+	t.Run("compounds are summed up", func(t *testing.T) {
+		ss, _ := ParseStylesheet(mr(`#a div.b > p.c.d {}`))
+		if got := ss.rules[0].selectors[0].specificity(); got != (specificity{1, 3, 2}) {
+			t.Error(got)
+		}
+	})
+	t.Run("attributes count as classes", func(t *testing.T) {
+		ss, _ := ParseStylesheet(mr(`a[href][target] {}`))
+		if got := ss.rules[0].selectors[0].specificity(); got != (specificity{0, 2, 1}) {
+			t.Error(got)
+		}
+	})
+	t.Run("pseudo-classes", func(t *testing.T) {
+		tests := []struct {
+			selector string
+			want     specificity
+		}{
+			{"li:first-child", specificity{0, 1, 1}},
+			{":where(#a, .b) p", specificity{0, 0, 1}},
+			{":is(#a, .b) p", specificity{1, 0, 1}},
+			{"p:not(.a, .b.c)", specificity{0, 2, 1}},
+			{"tr:nth-child(2n+1 of .x, #y)", specificity{1, 1, 1}},
+			{"tr:nth-of-type(odd)", specificity{0, 1, 1}},
+			{"div:has(> img, #x)", specificity{1, 0, 1}},
+			{"p::before", specificity{0, 0, 2}},
+		}
+		for _, tt := range tests {
+			sel, err := parseSelector(mr(tt.selector))
+			if err != nil {
+				t.Fatal(tt.selector, err)
+			}
+			if got := sel.specificity(); got != tt.want {
+				t.Error(tt.selector, got)
+			}
+		}
+	})
+	t.Run("id is greater than any number of classes", func(t *testing.T) {
+		if !(compareSpecificity(specificity{1, 0, 0}, specificity{0, 12, 3}) > 0) {
+			t.Error("id should win")
+		}
+	})
 }
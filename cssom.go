@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// CSS Object Model, https://www.w3.org/TR/cssom-1/#the-cssstylesheet-interface
+// Styled documents don't see edits of their sheets,
+// until they are told with StyledDocument.StylesheetChanged.
+
+// Rules returns style rules of the sheet in order,
+// those of @media and @container rules are flattened with their conditions
+func (s *Stylesheet) Rules() []*Rule {
+	return append([]*Rule{}, s.rules...)
+}
+
+// topLevelRules returns positions in the flattened rules where top-level rules start,
+// followed by the number of rules. Consecutive rules with the same outermost condition
+// are of one @media or @container rule, as they are serialized.
+func (s *Stylesheet) topLevelRules() []int {
+	starts := []int{}
+	outer := ""
+	for i, rule := range s.rules {
+		preludes := conditionText(rule, true)
+		if len(preludes) == 0 || preludes[0] != outer {
+			starts = append(starts, i)
+		}
+		outer = ""
+		if len(preludes) > 0 {
+			outer = preludes[0]
+		}
+	}
+	return append(starts, len(s.rules))
+}
+
+// InsertRule parses a style, @media or @container rule and inserts it before the top-level rule at index,
+// index equal to the number of top-level rules appends it. It returns index of the new rule.
+func (s *Stylesheet) InsertRule(text string, index int) (int, error) {
+	starts := s.topLevelRules()
+	if index < 0 || index >= len(starts) {
+		return 0, fmt.Errorf("index %d is out of range", index)
+	}
+	src := &lineCounter{r: strings.NewReader(text)}
+	r := bufio.NewReader(src)
+	skipSpaces(r)
+	var rules []*Rule
+	if isNextChar(r, '@') {
+		nested, err := parseAtRule(r, src, 1, nil, conditions{}, false)
+		if err != nil {
+			return 0, err
+		}
+		rules = nested
+	} else if rule, err := parseRule(r); err != nil {
+		return 0, err
+	} else if rule != nil {
+		rules = []*Rule{rule}
+	}
+	if len(rules) == 0 {
+		return 0, fmt.Errorf("rule is expected")
+	}
+	skipSpaces(r)
+	if !isNextCharEOF(r) {
+		return 0, fmt.Errorf("only one rule is expected")
+	}
+	at := starts[index]
+	s.rules = append(s.rules[:at], append(rules, s.rules[at:]...)...)
+	s.resetIndex()
+	return index, nil
+}
+
+// DeleteRule removes the top-level rule at index, with all rules inside it
+func (s *Stylesheet) DeleteRule(index int) error {
+	starts := s.topLevelRules()
+	if index < 0 || index >= len(starts)-1 {
+		return fmt.Errorf("index %d is out of range", index)
+	}
+	s.rules = append(s.rules[:starts[index]], s.rules[starts[index+1]:]...)
+	s.resetIndex()
+	return nil
+}
+
+// SelectorText returns selectors of the rule, separated by commas
+func (r *Rule) SelectorText() string {
+	return joinSelectors(r.selectors, (*Selector).format, false)
+}
+
+// PropertyValue returns serialized value of the last declaration of the property
+func (r *Rule) PropertyValue(name string) (string, bool) {
+	if d := r.declarator(name); d != nil {
+		return d.value.String(), true
+	}
+	return "", false
+}
+
+// PropertyPriority returns "important" if the property is declared with !important
+func (r *Rule) PropertyPriority(name string) string {
+	if d := r.declarator(name); d != nil && d.important {
+		return "important"
+	}
+	return ""
+}
+
+func (r *Rule) declarator(name string) *Declarator {
+	name = propertyName(name)
+	for i := len(r.declarators) - 1; i >= 0; i-- {
+		if r.declarators[i].name == name {
+			return r.declarators[i]
+		}
+	}
+	return nil
+}
+
+// SetProperty parses the value and sets the property, replacing its declarations.
+// Empty value removes the property.
+func (r *Rule) SetProperty(name, value string, important bool) error {
+	name = propertyName(name)
+	if strings.TrimSpace(value) == "" {
+		r.RemoveProperty(name)
+		return nil
+	}
+	reader := bufio.NewReader(strings.NewReader(name + ":" + value))
+	d, err := parseDeclarator(reader)
+	if err != nil {
+		return err
+	} else if d == nil || d.name != name {
+		return fmt.Errorf("invalid property name %q", name)
+	} else if _, _, err := reader.ReadRune(); err != io.EOF {
+		return fmt.Errorf("invalid value of %s: %q", name, value)
+	}
+	d.important = d.important || important
+	for i, old := range r.declarators {
+		if old.name == name {
+			// the declaration takes place of the first one of the property
+			r.RemoveProperty(name)
+			r.declarators = append(r.declarators[:i], append([]*Declarator{d}, r.declarators[i:]...)...)
+			return nil
+		}
+	}
+	r.declarators = append(r.declarators, d)
+	return nil
+}
+
+// RemoveProperty removes declarations of the property and returns its old value
+func (r *Rule) RemoveProperty(name string) string {
+	name = propertyName(name)
+	old, _ := r.PropertyValue(name)
+	declarators := r.declarators[:0]
+	for _, d := range r.declarators {
+		if d.name != name {
+			declarators = append(declarators, d)
+		}
+	}
+	r.declarators = declarators
+	return old
+}
+
+// propertyName returns name of the property as declarations keep it,
+// names of properties are case-insensitive, but those of custom properties are not
+func propertyName(name string) string {
+	if isCustomProperty(name) {
+		return name
+	}
+	return strings.ToLower(name)
+}
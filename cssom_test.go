@@ -0,0 +1,121 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStylesheetInsertDeleteRule(t *testing.T) {
+	sheet := mustParseStylesheet(t, `h1 {color: #cc0000} p {margin: 0}`)
+	sheet.getIndex()
+	if i, err := sheet.InsertRule(" div.note > p, a { padding: 10px } ", 1); err != nil || i != 1 {
+		t.Fatal(i, err)
+	}
+	if sheet.index != nil {
+		t.Error("index is not reset")
+	}
+	if _, err := sheet.InsertRule("em {}", 4); err == nil {
+		t.Error("index out of range is accepted")
+	}
+	for _, text := range []string{"", "em {} b {}", "em {color: #000000"} {
+		if _, err := sheet.InsertRule(text, 0); err == nil {
+			t.Errorf("%q is accepted", text)
+		}
+	}
+	if err := sheet.DeleteRule(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := sheet.DeleteRule(2); err == nil {
+		t.Error("index out of range is accepted")
+	}
+	selectors := []string{}
+	for _, rule := range sheet.Rules() {
+		selectors = append(selectors, rule.SelectorText())
+	}
+	if got := strings.Join(selectors, "; "); got != "div.note > p, a; p" {
+		t.Errorf("got selectors %q", got)
+	}
+	want := "div.note > p, a { padding: 10px; }\np { margin: 0; }"
+	if got := sheet.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestStylesheetInsertDeleteConditionRule(t *testing.T) {
+	sheet := mustParseStylesheet(t, `h1 {color: #cc0000} @media screen { p {margin: 0} @container (min-width: 10px) { a {margin: 1px} } } em {margin: 2px}`)
+	if i, err := sheet.InsertRule("@media print { a {color: #000000} }", 0); err != nil || i != 0 {
+		t.Fatal(i, err)
+	}
+	if i, err := sheet.InsertRule("@container (max-width: 20px) { b {color: #000000} i {color: #000001} }", 3); err != nil || i != 3 {
+		t.Fatal(i, err)
+	}
+	if _, err := sheet.InsertRule("@media print {}", 0); err == nil {
+		t.Error("empty @media rule is accepted")
+	}
+	if _, err := sheet.InsertRule("em {}", 6); err == nil {
+		t.Error("index out of range is accepted")
+	}
+	if err := sheet.DeleteRule(2); err != nil {
+		t.Fatal(err)
+	}
+	want := "@media print {\n  a { color: #000000; }\n}\nh1 { color: #cc0000; }\n" +
+		"@container (max-width: 20px) {\n  b { color: #000000; }\n  i { color: #000001; }\n}\nem { margin: 2px; }"
+	if got := sheet.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if len(sheet.Rules()) != 5 {
+		t.Errorf("got %d rules", len(sheet.Rules()))
+	}
+}
+
+func TestRuleSetProperty(t *testing.T) {
+	rule := mustParseStylesheet(t, `p {color: #000000; margin: 0; color: #ffffff !important}`).rules[0]
+	if v, ok := rule.PropertyValue("color"); !ok || v != "#ffffff" || rule.PropertyPriority("color") != "important" {
+		t.Errorf("got %q, %v", v, ok)
+	}
+	if err := rule.SetProperty("Color", "#ff0000", false); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := rule.PropertyValue("COLOR"); !ok || v != "#ff0000" {
+		t.Errorf("got %q, %v", v, ok)
+	}
+	if err := rule.SetProperty("padding", "1em 2em", true); err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []string{"red; margin: 1px", "1px }"} {
+		if err := rule.SetProperty("margin", value, false); err == nil {
+			t.Errorf("%q is accepted", value)
+		}
+	}
+	if old := rule.RemoveProperty("margin"); old != "0" {
+		t.Errorf("got old value %q", old)
+	}
+	if err := rule.SetProperty("color", " ", false); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rule.String(), "p { padding: 1em 2em !important; }"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestStylesheetEditRestyles(t *testing.T) {
+	node, err := parseHTMLWrapped(strings.NewReader(`<div><p>1</p><span>2</span></div>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sheet := mustParseStylesheet(t, `p {color: #000001}`)
+	doc := NewStyledDocument(node, sheet)
+	if _, err := sheet.InsertRule("span {color: #000002}", 1); err != nil {
+		t.Fatal(err)
+	}
+	doc.StylesheetChanged(sheet, sheet.rules[1])
+	sheet.rules[0].SetProperty("color", "#000003", false)
+	doc.StylesheetChanged(sheet, sheet.rules[0])
+	div := node.Children[0]
+	if got := doc.ComputedStyle(div.Children[0])["color"]; got != "rgb(0, 0, 3)" {
+		t.Errorf("got color of p %s", got)
+	}
+	if got := doc.ComputedStyle(div.Children[1])["color"]; got != "rgb(0, 0, 2)" {
+		t.Errorf("got color of span %s", got)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// StyledDocument is a document with its style tree and, once laid out, its layout tree
+type StyledDocument struct {
+	sheets []*Stylesheet
+	root   *styledNode
+	styled map[*Node]*styledNode
+	layout *layoutBox
+	boxes  map[*Node]*layoutBox
+	// viewport is the size given to Layout, for viewport units
+	viewport bounds
+	// features tell what changes of the document invalidate, nil until it is changed
+	features *invalidationMap
+	// media is what @media rules are evaluated against, the viewport is of the last layout.
+	// Stylers of query containers keep it, so it is changed in place.
+	media *mediaEnvironment
+}
+
+// NewStyledDocument styles the document with the sheets, like styleTree
+func NewStyledDocument(node *Node, sheets ...*Stylesheet) *StyledDocument {
+	return NewStyledDocumentWithOptions(node, DefaultRenderOptions, sheets...)
+}
+
+// NewStyledDocumentWithOptions is NewStyledDocument for the device and styling of the options
+func NewStyledDocumentWithOptions(node *Node, options RenderOptions, sheets ...*Stylesheet) *StyledDocument {
+	media := newMediaEnvironment(defaultViewport.width, defaultViewport.height, options)
+	doc := &StyledDocument{sheets: sheets, root: styleTreeMedia(node, media, sheets...), styled: make(map[*Node]*styledNode), media: media}
+	doc.indexStyled(doc.root)
+	return doc
+}
+
+// SetRenderOptions changes the device, which @media rules are evaluated against,
+// elements matched by rules, which start or stop to apply, are restyled
+func (doc *StyledDocument) SetRenderOptions(options RenderOptions) {
+	doc.setMedia(newMediaEnvironment(int(doc.media.width), int(doc.media.height), options))
+}
+
+func (doc *StyledDocument) setMedia(media *mediaEnvironment) {
+	doc.invalidateRules(mediaMatchChanged(withImports(doc.sheets), doc.media, media))
+	media.containers = doc.media.containers
+	*doc.media = *media
+}
+
+func (doc *StyledDocument) indexStyled(n *styledNode) {
+	doc.styled[n.node] = n
+	for _, child := range n.children {
+		doc.indexStyled(child)
+	}
+}
+
+// Layout lays out the document in the viewport, after it ComputedStyle
+// resolves sizes from the layout tree. @media rules are evaluated for the viewport,
+// @container rules for sizes of the containers, so the layout restyles their descendants.
+func (doc *StyledDocument) Layout(width, height int) {
+	doc.setMedia(newMediaEnvironment(width, height, doc.media.RenderOptions))
+	doc.Restyle()
+	doc.viewport = bounds{width, height}
+	// layout is nil if the root has display: none
+	doc.layout = nodesToBoxes(doc.root)
+	if doc.layout != nil {
+		doc.layout.fonts = documentFonts(doc.sheets)
+		doc.layout.layoutRoot(width, height)
+	}
+	if len(doc.media.containers) > 0 {
+		// content after containers depends on counters and quotes of their descendants,
+		// which the layout may have styled again
+		generateContent(doc.root)
+	}
+	doc.styled = make(map[*Node]*styledNode)
+	doc.indexStyled(doc.root)
+	doc.boxes = make(map[*Node]*layoutBox)
+	if doc.layout != nil {
+		doc.indexBoxes(doc.layout)
+	}
+}
+
+// indexBoxes maps nodes to their first boxes, boxes of the first line
+// are copies of styled nodes, so nodes are the keys
+func (doc *StyledDocument) indexBoxes(box *layoutBox) {
+	if box.styledNode != nil {
+		if _, ok := doc.boxes[box.styledNode.node]; !ok {
+			doc.boxes[box.styledNode.node] = box
+		}
+	}
+	for _, child := range box.children {
+		doc.indexBoxes(child)
+	}
+}
+
+// usedSizes are properties, which resolved values come from the layout tree,
+// https://www.w3.org/TR/cssom-1/#resolved-values
+var usedSizes = map[string]func(d dimensions) float32{
+	"width":          func(d dimensions) float32 { return d.content.width },
+	"height":         func(d dimensions) float32 { return d.content.height },
+	"margin-top":     func(d dimensions) float32 { return d.margin.top },
+	"margin-right":   func(d dimensions) float32 { return d.margin.right },
+	"margin-bottom":  func(d dimensions) float32 { return d.margin.bottom },
+	"margin-left":    func(d dimensions) float32 { return d.margin.left },
+	"padding-top":    func(d dimensions) float32 { return d.padding.top },
+	"padding-right":  func(d dimensions) float32 { return d.padding.right },
+	"padding-bottom": func(d dimensions) float32 { return d.padding.bottom },
+	"padding-left":   func(d dimensions) float32 { return d.padding.left },
+}
+
+// ComputedStyle returns resolved values of the node as CSS text, like getComputedStyle:
+// lengths are in px and colors are rgb(). Sizes come from the layout tree
+// if the document is laid out and the node has a box.
+// It returns nil for nodes out of the document.
+func (doc *StyledDocument) ComputedStyle(node *Node) map[string]string {
+	doc.Restyle()
+	styled, ok := doc.styled[node]
+	if !ok {
+		return nil
+	}
+	computed := withCustomProperties(styled.computedValues)
+	style := make(map[string]string, len(computed))
+	for name, v := range computed {
+		style[name] = doc.resolvedText(v)
+	}
+	if box := doc.boxes[node]; box != nil && box.boxType == blockBox {
+		for name, used := range usedSizes {
+			style[name] = formatPx(used(box.dimensions))
+		}
+	}
+	return style
+}
+
+// resolvedText serializes computed value with lengths converted to px
+func (doc *StyledDocument) resolvedText(v Value) string {
+	switch v.valueType {
+	case Keyword:
+		return v.keyword
+	case Length:
+		return doc.resolvedLength(v)
+	case Number:
+		return formatNumber(v.length)
+	case ColorValue:
+		if v.color.A != 255 {
+			return fmt.Sprintf("rgba(%d, %d, %d, %s)", v.color.R, v.color.G, v.color.B, formatNumber(float32(v.color.A)/255))
+		}
+		return fmt.Sprintf("rgb(%d, %d, %d)", v.color.R, v.color.G, v.color.B)
+	case StringValue:
+		return strconv.Quote(v.text)
+	case Tokens:
+		return v.text
+	case FunctionValue:
+		args := []string{}
+		for _, arg := range v.args {
+			args = append(args, doc.resolvedText(arg))
+		}
+		return v.function + "(" + strings.Join(args, ", ") + ")"
+	case ListValue:
+		items := []string{}
+		for _, item := range v.list {
+			items = append(items, doc.resolvedText(item))
+		}
+		if v.commas {
+			return strings.Join(items, ", ")
+		}
+		return strings.Join(items, " ")
+	}
+	return ""
+}
+
+func (doc *StyledDocument) resolvedLength(v Value) string {
+	w, h := float32(doc.viewport.width), float32(doc.viewport.height)
+	switch v.unitType {
+	case Percent:
+		return formatNumber(v.length) + "%"
+	case Vw:
+		return formatPx(v.length * w / 100)
+	case Vh:
+		return formatPx(v.length * h / 100)
+	case Vmin:
+		if h < w {
+			w = h
+		}
+		return formatPx(v.length * w / 100)
+	case Vmax:
+		if h > w {
+			w = h
+		}
+		return formatPx(v.length * w / 100)
+	}
+	return formatPx(v.toPx())
+}
+
+func formatPx(px float32) string {
+	return formatNumber(px) + "px"
+}
+
+func formatNumber(n float32) string {
+	return strconv.FormatFloat(float64(n), 'f', -1, 32)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestComputedStyle(t *testing.T) {
+	node, err := parseHTMLWrapped(strings.NewReader(`<div><p>text</p><span>s</span></div>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	style, err := ParseStylesheet(strings.NewReader(`div {display: block; width: 50%; font-size: 12pt; color: #ff8000; padding-left: 10%}
+		p {display: block; margin-top: 2em; opacity: 0; z-index: 0; text-indent: 0} span {quotes: "<" ">"; width: 20%; height: 10vh}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := NewStyledDocument(node, style)
+	div := node.Children[0]
+	p, span := div.Children[0], div.Children[1]
+
+	cs := doc.ComputedStyle(div)
+	if cs["width"] != "50%" || cs["font-size"] != "16px" || cs["color"] != "rgb(255, 128, 0)" ||
+		cs["background-color"] != "rgba(0, 0, 0, 0)" {
+		t.Error("wrong values before layout", cs)
+	}
+
+	doc.Layout(800, 600)
+	tests := []struct {
+		node       *Node
+		name, want string
+	}{
+		{div, "width", "400px"},
+		{div, "padding-left", "80px"},
+		{div, "margin-left", "0px"},
+		{p, "margin-top", "32px"},
+		{span, "height", "60px"},
+		{p, "display", "block"},
+		{p, "opacity", "0"},
+		{p, "z-index", "0"},
+		{p, "text-indent", "0px"},
+		{p, "color", "rgb(255, 128, 0)"},
+		{span, "quotes", `"<" ">"`},
+		{span, "width", "20%"},
+		{p.Children[0], "font-size", "16px"},
+	}
+	for _, tt := range tests {
+		if got := doc.ComputedStyle(tt.node)[tt.name]; got != tt.want {
+			t.Errorf("%s of %s = %q, want %q", tt.name, tt.node.TagName(), got, tt.want)
+		}
+	}
+	if doc.ComputedStyle(&Node{}) != nil {
+		t.Error("nodes out of the document have no style")
+	}
+}
+
+func TestComputedStyleKeywords(t *testing.T) {
+	node, err := parseHTMLWrapped(strings.NewReader(`<div style="color: Red; background-color: transparent"><p style="display: NONE; border-left: 1px solid; background-color: currentColor">p</p><span style="color: currentcolor; border-color: RebeccaPurple">s</span></div>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := NewStyledDocument(node)
+	div := node.Children[0]
+	p, span := div.Children[0], div.Children[1]
+	tests := []struct {
+		node       *Node
+		name, want string
+	}{
+		{div, "color", "rgb(255, 0, 0)"},
+		{div, "background-color", "rgba(0, 0, 0, 0)"},
+		{p, "display", "none"},
+		{p, "border-left-color", "rgb(255, 0, 0)"},
+		{p, "background-color", "rgb(255, 0, 0)"},
+		{span, "color", "rgb(255, 0, 0)"},
+		{span, "border-top-color", "rgb(102, 51, 153)"},
+	}
+	for _, tt := range tests {
+		if got := doc.ComputedStyle(tt.node)[tt.name]; got != tt.want {
+			t.Errorf("%s of %s = %q, want %q", tt.name, tt.node.TagName(), got, tt.want)
+		}
+	}
+}
+
+func TestLayoutOfHiddenRoot(t *testing.T) {
+	node, err := parseHTMLWrapped(strings.NewReader(`<html style="display: none"><body>b</body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := NewStyledDocument(node.Children[0])
+	doc.Layout(800, 600)
+	if len(doc.boxes) != 0 {
+		t.Errorf("got %d boxes", len(doc.boxes))
+	}
+	if got := doc.ComputedStyle(node.Children[0])["display"]; got != "none" {
+		t.Error("got display", got)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Cascade tracing, which answers why an element has its values
+
+// DeclarationTrace describes a declaration, which took part in the cascade of a property
+type DeclarationTrace struct {
+	Value       string
+	Important   bool
+	Origin      Origin
+	Specificity [3]int
+	// Selector is the matched selector of the rule,
+	// empty for style attribute and presentational hints
+	Selector string
+	// Source is href of the sheet, "sheet N" for sheets without it,
+	// "style attribute" or "presentational hint"
+	Source string
+	// Line is where the rule starts in its sheet, 0 if it is not from a sheet
+	Line int
+}
+
+// PropertyTrace explains the computed value of a property of an element
+type PropertyTrace struct {
+	Property string
+	Value    string
+	// Winner is the declaration, which set the value, the one of InheritedFrom
+	// if the value is inherited, nil if the value is initial
+	Winner *DeclarationTrace
+	// Overridden are declarations, which lost to the winner, the strongest first
+	Overridden []DeclarationTrace
+	// InheritedFrom is the ancestor, which the value is inherited from
+	InheritedFrom *Node
+}
+
+var originNames = map[Origin]string{
+	AuthorOrigin:    "author",
+	UserOrigin:      "user",
+	UserAgentOrigin: "user agent",
+}
+
+func (o Origin) String() string {
+	return originNames[o]
+}
+
+func (d *DeclarationTrace) String() string {
+	s := d.Value
+	if d.Important {
+		s += " !important"
+	}
+	if d.Selector != "" {
+		s += " from " + d.Selector
+	}
+	s += fmt.Sprintf(" (%s, specificity %d,%d,%d) in %s", d.Origin, d.Specificity[0], d.Specificity[1], d.Specificity[2], d.Source)
+	if d.Line > 0 {
+		s += fmt.Sprintf(":%d", d.Line)
+	}
+	return s
+}
+
+func (t *PropertyTrace) String() string {
+	b := new(strings.Builder)
+	fmt.Fprintf(b, "%s: %s\n", t.Property, t.Value)
+	if t.InheritedFrom != nil {
+		fmt.Fprintf(b, "  inherited from <%s>\n", t.InheritedFrom.TagName())
+	}
+	if t.Winner == nil {
+		b.WriteString("  initial value\n")
+	} else {
+		fmt.Fprintf(b, "  set by %s\n", t.Winner)
+	}
+	for i := range t.Overridden {
+		fmt.Fprintf(b, "  overrides %s\n", &t.Overridden[i])
+	}
+	return b.String()
+}
+
+// Explain reports for every computed property of the node, which declaration
+// set its value and which ones it beat. Properties are sorted by name.
+// It returns nil for nodes out of the document.
+func (doc *StyledDocument) Explain(node *Node) []PropertyTrace {
+	doc.Restyle()
+	styled, ok := doc.styled[node]
+	if !ok {
+		return nil
+	}
+	cascades := make(map[*Node]map[string]declaredTrace)
+	declared := doc.cascadeTraces(styled)
+	traces := []PropertyTrace{}
+	for name, v := range withCustomProperties(styled.computedValues) {
+		trace := PropertyTrace{Property: name, Value: v.String()}
+		if d, ok := declared[name]; ok {
+			trace.Winner, trace.Overridden = d.winner, d.overridden
+		}
+		inherited := properties[name].inherited
+		if trace.Winner == nil && inherited || trace.Winner != nil && (trace.Winner.Value == "inherit" || trace.Winner.Value == "unset" && inherited) {
+			doc.traceInherited(&trace, styled, cascades)
+		}
+		traces = append(traces, trace)
+	}
+	sort.Slice(traces, func(i, j int) bool {
+		return traces[i].Property < traces[j].Property
+	})
+	return traces
+}
+
+type declaredTrace struct {
+	winner     *DeclarationTrace
+	overridden []DeclarationTrace
+}
+
+// cascadeTraces runs the cascade of the node again and splits matched declarations
+// of each property to the winner and the overridden ones
+func (doc *StyledDocument) cascadeTraces(styled *styledNode) map[string]declaredTrace {
+	element := styled.node
+	if styled.pseudo != "" {
+		element = styled.node.Parent
+	}
+	if element == nil || element.NodeType != ElementNode {
+		return nil
+	}
+	var ctx *matchContext
+	matched := expandShorthands(ctx.cascade(element, styled.pseudo, withImports(doc.sheets), doc.media, nil))
+	winners := make(map[string]int)
+	for i := range matched {
+		winners[matched[i].decl.name] = i
+	}
+	result := make(map[string]declaredTrace)
+	for name, last := range winners {
+		winner := winningIndex(matched, last)
+		d := declaredTrace{}
+		for i := last; i >= 0; i-- {
+			if matched[i].decl.name != name {
+				continue
+			}
+			trace := doc.declarationTrace(element, styled.pseudo, &matched[i])
+			if i == winner {
+				d.winner = &trace
+			} else {
+				d.overridden = append(d.overridden, trace)
+			}
+		}
+		result[name] = d
+	}
+	return result
+}
+
+func (doc *StyledDocument) declarationTrace(element *Node, pseudo string, m *matchedDeclaration) DeclarationTrace {
+	trace := DeclarationTrace{
+		Value:       m.decl.value.String(),
+		Important:   m.decl.important,
+		Origin:      m.origin,
+		Specificity: m.specificity,
+	}
+	sheetIndex := m.position[0]
+	switch {
+	case m.inline:
+		trace.Source = "style attribute"
+	case sheetIndex < 0:
+		trace.Source = "presentational hint"
+	default:
+		sheet := withImports(doc.sheets)[sheetIndex]
+		rule := sheet.rules[m.position[1]]
+		trace.Source = sheet.href
+		if trace.Source == "" {
+			trace.Source = fmt.Sprintf("sheet %d", sheetIndex+1)
+		}
+		trace.Line = rule.line
+		for _, sel := range rule.selectors {
+			if sel.pseudoElement == pseudo && sel.specificity() == m.specificity && matches(element, sel) {
+				trace.Selector = sel.String()
+				break
+			}
+		}
+	}
+	return trace
+}
+
+// traceInherited finds the nearest ancestor, which declares the property,
+// cascades of ancestors are kept between calls
+func (doc *StyledDocument) traceInherited(trace *PropertyTrace, styled *styledNode, cascades map[*Node]map[string]declaredTrace) {
+	for p := styled.node.Parent; p != nil; p = p.Parent {
+		ancestor, ok := doc.styled[p]
+		if !ok {
+			return
+		}
+		declared, ok := cascades[p]
+		if !ok {
+			declared = doc.cascadeTraces(ancestor)
+			cascades[p] = declared
+		}
+		d, ok := declared[trace.Property]
+		if ok && d.winner != nil && d.winner.Value != "inherit" && d.winner.Value != "unset" {
+			trace.InheritedFrom = p
+			trace.Winner = d.winner
+			return
+		}
+	}
+}
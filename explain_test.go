@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	node, err := parseHTMLWrapped(strings.NewReader(`<div><p class="note" style="margin-left: 3px">text</p></div>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, _ := ParseStylesheetOrigin(strings.NewReader(`p {color: #000001 !important}`), UserOrigin)
+	author, err := ParseStylesheet(strings.NewReader(`div {font-size: 20px}
+
+p {color: #000002; margin-left: 1px}
+div > p.note {
+	color: #000003 !important;
+	display: block
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	author.href = "style.css"
+	doc := NewStyledDocument(node, user, author)
+	p := node.Children[0].Children[0]
+	traces := map[string]PropertyTrace{}
+	for _, trace := range doc.Explain(p) {
+		traces[trace.Property] = trace
+	}
+
+	color := traces["color"]
+	if color.Value != "#000001" || color.Winner == nil || color.Winner.Origin != UserOrigin || !color.Winner.Important {
+		t.Fatal("important user declaration should win", color.String())
+	}
+	if len(color.Overridden) != 2 {
+		t.Fatal("should override 2 declarations", color.String())
+	}
+	if o := color.Overridden[0]; o.Selector != "div > p.note" || o.Line != 4 || o.Source != "style.css" || o.Specificity != [3]int{0, 1, 2} {
+		t.Error("wrong overridden declaration", o.String())
+	}
+	if o := color.Overridden[1]; o.Selector != "p" || o.Line != 3 {
+		t.Error("wrong overridden declaration", o.String())
+	}
+
+	if margin := traces["margin-left"]; margin.Winner.Source != "style attribute" || margin.Overridden[0].Value != "1px" {
+		t.Error("style attribute should win", margin.String())
+	}
+	if fs := traces["font-size"]; fs.InheritedFrom != node.Children[0] || fs.Winner.Line != 1 || fs.Value != "20px" {
+		t.Error("font-size should be inherited from div", fs.String())
+	}
+	if fw := traces["font-weight"]; fw.Winner != nil || fw.InheritedFrom != nil || fw.Value != "normal" {
+		t.Error("font-weight should be initial", fw.String())
+	}
+	if doc.Explain(&Node{}) != nil {
+		t.Error("nodes out of the document are not explained")
+	}
+}
+
+func Test_ruleLines(t *testing.T) {
+	s, err := ParseStylesheet(strings.NewReader("a {color: #000000}\n\n  b {color: #000000} c {display: block}\nd\n{display: none}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int{1, 3, 3, 4}
+	for i, rule := range s.rules {
+		if rule.line != want[i] {
+			t.Errorf("rule %d at line %d, want %d", i, rule.line, want[i])
+		}
+	}
+	_, err = ParseStylesheet(strings.NewReader("a {color: #000000}\nb {color: #000000"))
+	if err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Error("error should tell line", err)
+	}
+}
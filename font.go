@@ -5,6 +5,7 @@ import (
 	"image/draw"
 	"io/ioutil"
 	"log"
+	"strings"
 
 	"github.com/golang/freetype"
 	"github.com/golang/freetype/truetype"
@@ -40,12 +41,86 @@ func initFont(fontFile string) *truetype.Font {
 	// c.SetSrc(fg)
 }
 
-func drawString(s string, img draw.Image, pt image.Point) {
+// parsedFont is the font of @font-face rule, it is parsed when the rule is loaded
+type parsedFont = *truetype.Font
+
+// parseFont parses TrueType or OpenType font
+func parseFont(data []byte) (parsedFont, error) {
+	return freetype.ParseFont(data)
+}
+
+// fontRegistry has faces of @font-face rules of a document by family in lower case,
+// each document has its own, so that fonts of one document don't leak into others
+type fontRegistry map[string][]registeredFont
+
+type registeredFont struct {
+	weight int
+	italic bool
+	font   *truetype.Font
+}
+
+// register makes the font the face of the family with the weight and style,
+// replacing the face registered before
+func (r fontRegistry) register(family string, weight int, italic bool, f parsedFont) {
+	key := strings.ToLower(family)
+	faces := r[key]
+	for i := range faces {
+		if faces[i].weight == weight && faces[i].italic == italic {
+			faces[i].font = f
+			return
+		}
+	}
+	r[key] = append(faces, registeredFont{weight, italic, f})
+}
+
+// lookup returns the registered face of the family, which matches the style,
+// and then has the closest weight, or the default font if the family has no faces
+func (r fontRegistry) lookup(family string, weight int, italic bool) *truetype.Font {
+	best, bestDistance := font, -1
+	for _, face := range r[strings.ToLower(family)] {
+		distance := face.weight - weight
+		if distance < 0 {
+			distance = -distance
+		}
+		if face.italic != italic {
+			distance += 1000
+		}
+		if bestDistance < 0 || distance < bestDistance {
+			best, bestDistance = face.font, distance
+		}
+	}
+	return best
+}
+
+// styleFont returns the face of the first family of the style, which has faces among fonts
+// of the style, or the default font if none of them has
+func styleFont(style fontStyle) *truetype.Font {
+	for _, family := range style.families {
+		if f := style.fonts.lookup(family, style.weight, style.italic); f != font {
+			return f
+		}
+	}
+	return font
+}
+
+// fontSize returns size of the style in points, the default font size is drawn with size points
+func fontSize(style fontStyle) float64 {
+	if style.size <= 0 {
+		return size
+	}
+	return size * float64(style.size) / defaultFontSize
+}
+
+func faceOptions(style fontStyle) *truetype.Options {
+	return &truetype.Options{Size: fontSize(style), DPI: dpi}
+}
+
+func drawString(s string, style fontStyle, img draw.Image, pt image.Point) {
 	fg, _ := image.Black, image.White
 	c := freetype.NewContext()
 	c.SetDPI(dpi)
-	c.SetFont(font)
-	c.SetFontSize(size)
+	c.SetFont(styleFont(style))
+	c.SetFontSize(fontSize(style))
 	c.SetClip(img.Bounds())
 	c.SetDst(img)
 	c.SetSrc(fg)
@@ -57,8 +132,8 @@ func drawString(s string, img draw.Image, pt image.Point) {
 	}
 }
 
-func getStringWidth(s string) int {
-	face := truetype.NewFace(font, nil)
+func getStringWidth(s string, style fontStyle) int {
+	face := truetype.NewFace(styleFont(style), faceOptions(style))
 	total := 0
 	for _, r := range s {
 		p, ok := face.GlyphAdvance(r)
@@ -70,7 +145,7 @@ func getStringWidth(s string) int {
 	return total
 }
 
-func getFontHeight() int {
-	face := truetype.NewFace(font, nil)
+func getFontHeight(style fontStyle) int {
+	face := truetype.NewFace(styleFont(style), faceOptions(style))
 	return face.Metrics().Height.Round()
 }
@@ -1,14 +1,71 @@
 package main
 
-import "testing"
+import (
+	"os"
+	"testing"
+)
 
 func Test_getStringWidth(t *testing.T) {
 	t.Run("", func(t *testing.T) {
 		s := "Some test string."
-		w1 := getStringWidth(s)
-		w2 := getStringWidth(s + s)
+		w1 := getStringWidth(s, fontStyle{})
+		w2 := getStringWidth(s+s, fontStyle{})
 		if w1*2 != w2 {
 			t.Errorf("%d * 2 != %d", w1, w2)
 		}
 	})
 }
+
+func Test_fontRegistry(t *testing.T) {
+	fonts := make(fontRegistry)
+	for _, face := range []struct {
+		file   string
+		weight int
+		italic bool
+	}{
+		{"fonts/PlayfairDisplay-Regular.ttf", 400, false},
+		{"fonts/PlayfairDisplay-Bold.ttf", 700, false},
+		{"fonts/PlayfairDisplay-Italic.ttf", 400, true},
+	} {
+		data, err := os.ReadFile(face.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := parseFont(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fonts.register("Brand Test", face.weight, face.italic, f)
+	}
+	regular, bold, italic := fonts.lookup("brand test", 400, false), fonts.lookup("Brand Test", 700, false), fonts.lookup("Brand Test", 400, true)
+	if regular == bold || regular == italic || bold == italic {
+		t.Error("faces are not told apart")
+	}
+	if fonts.lookup("Brand Test", 900, false) != bold || fonts.lookup("Brand Test", 700, true) != italic {
+		t.Error("closest faces are not found")
+	}
+	if fonts.lookup("Unknown", 400, false) != font {
+		t.Error("default font is not used for unknown family")
+	}
+	if _, err := parseFont([]byte("not a font")); err == nil {
+		t.Error("broken font is parsed")
+	}
+	t.Run("measuring", func(t *testing.T) {
+		s := "Some test string."
+		unknown := getStringWidth(s, fontStyle{families: []string{"Unknown"}, fonts: fonts})
+		if unknown != getStringWidth(s, fontStyle{}) {
+			t.Error("default font is not used for unknown family")
+		}
+		regular := getStringWidth(s, fontStyle{families: []string{"Unknown", "Brand Test"}, weight: 400, fonts: fonts})
+		bold := getStringWidth(s, fontStyle{families: []string{"Brand Test"}, weight: 700, fonts: fonts})
+		if regular == unknown || regular == bold {
+			t.Errorf("registered faces aren't used: default %d, regular %d, bold %d", unknown, regular, bold)
+		}
+		if other := getStringWidth(s, fontStyle{families: []string{"Brand Test"}, weight: 400}); other != unknown {
+			t.Error("faces of one document are used by another")
+		}
+		if large := getStringWidth(s, fontStyle{families: []string{"Brand Test"}, weight: 400, size: 32, fonts: fonts}); large <= regular {
+			t.Errorf("width %d of larger font isn't more than %d", large, regular)
+		}
+	})
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"image/color"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Presentational hints of legacy HTML attributes,
+// https://html.spec.whatwg.org/multipage/rendering.html#presentational-hints
+// They enter the cascade as author declarations with zero specificity,
+// preceding all author stylesheets.
+
+var fontSizes = []string{"x-small", "small", "medium", "large", "x-large", "xx-large", "xxx-large"}
+
+var cellTags = map[string]bool{"td": true, "th": true}
+
+var dimensionTags = map[string]bool{
+	"img": true, "table": true, "td": true, "th": true, "col": true, "hr": true,
+	"iframe": true, "embed": true, "object": true, "video": true, "canvas": true,
+}
+
+var textAlignTags = map[string]bool{
+	"div": true, "p": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"caption": true, "legend": true, "td": true, "th": true, "tr": true,
+	"thead": true, "tbody": true, "tfoot": true,
+}
+
+var verticalAlignTags = map[string]bool{
+	"td": true, "th": true, "tr": true, "thead": true, "tbody": true, "tfoot": true, "col": true,
+}
+
+// presentationalHints returns declarations for presentational attributes of the element
+func presentationalHints(node *Node) []*Declarator {
+	if node.NodeType != ElementNode {
+		return nil
+	}
+	hints := []*Declarator{}
+	add := func(name string, value Value) {
+		hints = append(hints, &Declarator{name: name, value: value})
+	}
+	tag := node.TagName()
+	attr := func(name string) (string, bool) {
+		v, ok := node.Attributes[name]
+		return strings.TrimSpace(v), ok
+	}
+
+	if _, ok := attr("hidden"); ok {
+		add("display", keywordValue("none"))
+	}
+	if v, ok := attr("bgcolor"); ok {
+		if c, ok := parseLegacyColor(v); ok {
+			add("background-color", colorValue(c))
+		}
+	}
+	if dimensionTags[tag] {
+		if v, ok := attr("width"); ok {
+			if d, ok := parseDimension(v); ok {
+				add("width", d)
+			}
+		}
+		if v, ok := attr("height"); ok {
+			if d, ok := parseDimension(v); ok {
+				add("height", d)
+			}
+		}
+	}
+	if v, ok := attr("align"); ok {
+		v = strings.ToLower(v)
+		switch {
+		case tag == "table" && v == "center":
+			add("margin-left", keywordValue("auto"))
+			add("margin-right", keywordValue("auto"))
+		case (tag == "table" || tag == "img") && (v == "left" || v == "right"):
+			add("float", keywordValue(v))
+		case tag == "img" && (v == "top" || v == "middle" || v == "bottom"):
+			add("vertical-align", keywordValue(v))
+		case textAlignTags[tag] && (v == "left" || v == "right" || v == "center" || v == "justify"):
+			add("text-align", keywordValue(v))
+		}
+	}
+	if v, ok := attr("valign"); ok && verticalAlignTags[tag] {
+		v = strings.ToLower(v)
+		if v == "top" || v == "middle" || v == "bottom" || v == "baseline" {
+			add("vertical-align", keywordValue(v))
+		}
+	}
+	if v, ok := attr("border"); ok && (tag == "table" || tag == "img") {
+		if n, ok := parseNonNegativeInteger(v); ok {
+			add("border-width", pxValue(float32(n)))
+			add("border-style", keywordValue("solid"))
+		} else if v == "" && tag == "table" {
+			add("border-width", pxValue(1))
+			add("border-style", keywordValue("solid"))
+		}
+	}
+	if cellTags[tag] {
+		if table := ancestorTable(node); table != nil {
+			if v, ok := table.Attributes["cellpadding"]; ok {
+				if n, ok := parseNonNegativeInteger(v); ok {
+					add("padding", pxValue(float32(n)))
+				}
+			}
+			if v, ok := table.Attributes["border"]; ok {
+				if n, ok := parseNonNegativeInteger(v); v == "" || ok && n > 0 {
+					add("border-width", pxValue(1))
+					add("border-style", keywordValue("solid"))
+				}
+			}
+		}
+	}
+	if tag == "font" {
+		if v, ok := attr("color"); ok {
+			if c, ok := parseLegacyColor(v); ok {
+				add("color", colorValue(c))
+			}
+		}
+		if v, ok := attr("face"); ok && v != "" {
+			add("font-family", keywordValue(v))
+		}
+		if v, ok := attr("size"); ok {
+			if size, ok := parseLegacyFontSize(v); ok {
+				add("font-size", keywordValue(size))
+			}
+		}
+	}
+	if tag == "center" {
+		add("text-align", keywordValue("center"))
+	}
+	return hints
+}
+
+func ancestorTable(node *Node) *Node {
+	for p := node.ParentElement(); p != nil; p = p.ParentElement() {
+		if p.TagName() == "table" {
+			return p
+		}
+	}
+	return nil
+}
+
+var legacyDimension = regexp.MustCompile(`^(\d+(?:\.\d+)?)(%?)`)
+
+// parseDimension parses dimension value like "100" or "50%", ignoring anything after it
+func parseDimension(s string) (Value, bool) {
+	m := legacyDimension.FindStringSubmatch(s)
+	if m == nil {
+		return Value{}, false
+	}
+	n, err := strconv.ParseFloat(m[1], 32)
+	if err != nil {
+		return Value{}, false
+	}
+	if m[2] == "%" {
+		return Value{valueType: Length, length: float32(n), unitType: Percent}, true
+	}
+	return pxValue(float32(n)), true
+}
+
+var leadingDigits = regexp.MustCompile(`^\d+`)
+
+func parseNonNegativeInteger(s string) (int, bool) {
+	m := leadingDigits.FindString(strings.TrimSpace(s))
+	if m == "" {
+		return 0, false
+	}
+	n, err := strconv.Atoi(m)
+	return n, err == nil
+}
+
+// parseLegacyFontSize converts size of <font> like "5" or "+2" to font-size keyword
+func parseLegacyFontSize(s string) (string, bool) {
+	if s == "" {
+		return "", false
+	}
+	relative := s[0] == '+' || s[0] == '-'
+	n, err := strconv.Atoi(strings.TrimPrefix(s, "+"))
+	if err != nil {
+		return "", false
+	}
+	if relative {
+		n += 3
+	}
+	if n < 1 {
+		n = 1
+	} else if n > 7 {
+		n = 7
+	}
+	return fontSizes[n-1], true
+}
+
+// parseLegacyColor parses simplified legacy color value: color name,
+// or six or three hex digits, with or without #
+func parseLegacyColor(s string) (color.RGBA, bool) {
+	s = strings.ToLower(s)
+	if c, ok := namedColors[s]; ok {
+		return c, true
+	}
+	s = strings.TrimPrefix(s, "#")
+	if len(s) == 3 {
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]})
+	}
+	if len(s) != 6 {
+		return color.RGBA{}, false
+	}
+	n, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return color.RGBA{}, false
+	}
+	return color.RGBA{uint8(n >> 16), uint8(n >> 8), uint8(n), 255}, true
+}
+
+func keywordValue(keyword string) Value {
+	return Value{valueType: Keyword, keyword: keyword}
+}
+
+func pxValue(px float32) Value {
+	return Value{valueType: Length, length: px, unitType: Px}
+}
+
+func colorValue(c color.RGBA) Value {
+	return Value{valueType: ColorValue, color: c}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_presentationalHints(t *testing.T) {
+	html := `<table bgcolor="#ffcc00" width="80%" border="2" cellpadding="4" align="center"><tr valign="top"><td width="100" align="right">x</td></tr></table><font color="red" size="+2" face="Georgia">f</font><center>c</center><p hidden="">h</p><img width="50" height="20px" align="left" border="0"></img>`
+	root, err := parseHTMLWrapped(strings.NewReader(html))
+	if err != nil {
+		t.Fatal(err)
+	}
+	styled := styleTree(root)
+	table := styled.children[0]
+	tr := table.children[0]
+	td := tr.children[0]
+	font, center, p, img := styled.children[1], styled.children[2], styled.children[3], styled.children[4]
+
+	checks := []struct {
+		name string
+		ok   bool
+	}{
+		{"table bgcolor", table.specifiedValues["background-color"].color.G == 0xcc},
+		{"table width", table.specifiedValues["width"].unitType == Percent && table.specifiedValues["width"].length == 80},
+		{"table border", table.specifiedValues["border-width"].length == 2},
+		{"table align", table.specifiedValues["margin-left"].isKeyword("auto")},
+		{"tr valign", tr.specifiedValues["vertical-align"].isKeyword("top")},
+		{"td width", td.specifiedValues["width"].length == 100 && td.specifiedValues["width"].unitType == Px},
+		{"td align", td.specifiedValues["text-align"].isKeyword("right")},
+		{"td cellpadding", td.specifiedValues["padding"].length == 4},
+		{"td border of table", td.specifiedValues["border-width"].length == 1},
+		{"font color", font.specifiedValues["color"].color.R == 255},
+		{"font size", font.specifiedValues["font-size"].isKeyword("x-large")},
+		{"font face", font.specifiedValues["font-family"].isKeyword("Georgia")},
+		{"center", center.specifiedValues["text-align"].isKeyword("center")},
+		{"hidden", p.specifiedValues["display"].isKeyword("none")},
+		{"img size", img.specifiedValues["width"].length == 50 && img.specifiedValues["height"].length == 20},
+		{"img align", img.specifiedValues["float"].isKeyword("left")},
+		{"img border", img.specifiedValues["border-width"].length == 0 && pmapContainsKey(img.specifiedValues, "border-width")},
+	}
+	for _, c := range checks {
+		if !c.ok {
+			t.Error(c.name)
+		}
+	}
+}
+
+func Test_presentationalHintsCascade(t *testing.T) {
+	root, _ := parseHTMLWrapped(strings.NewReader(`<td bgcolor="red" width="10">x</td>`))
+	t.Run("any author rule wins", func(t *testing.T) {
+		style, _ := ParseStylesheet(strings.NewReader(`* {background-color: #0000ff}`))
+		td := styleTree(root, style).children[0]
+		if td.specifiedValues["background-color"].color.B != 255 || td.specifiedValues["width"].length != 10 {
+			t.Error(td.specifiedValues)
+		}
+	})
+	t.Run("hint wins over user agent", func(t *testing.T) {
+		style, _ := ParseStylesheetOrigin(strings.NewReader(`td {background-color: #0000ff}`), UserAgentOrigin)
+		td := styleTree(root, style).children[0]
+		if td.specifiedValues["background-color"].color.R != 255 {
+			t.Error(td.specifiedValues)
+		}
+	})
+	t.Run("cellpadding wins over user agent padding", func(t *testing.T) {
+		root, _ := parseHTMLWrapped(strings.NewReader(`<table cellpadding="10"><tr><td>x</td></tr></table>`))
+		doc := NewStyledDocument(root, UserAgentStylesheet())
+		doc.Layout(800, 600)
+		td := root.Children[0].Children[0].Children[0]
+		if got := doc.ComputedStyle(td)["padding-left"]; got != "10px" {
+			t.Error("got padding-left", got)
+		}
+	})
+}
+
+func Test_parseLegacyColor(t *testing.T) {
+	tests := []struct {
+		in      string
+		r, g, b uint8
+		ok      bool
+	}{
+		{"#102030", 0x10, 0x20, 0x30, true},
+		{"102030", 0x10, 0x20, 0x30, true},
+		{"#fc0", 0xff, 0xcc, 0x00, true},
+		{"Navy", 0, 0, 128, true},
+		{"#12345", 0, 0, 0, false},
+		{"nonsense", 0, 0, 0, false},
+	}
+	for _, tt := range tests {
+		c, ok := parseLegacyColor(tt.in)
+		if ok != tt.ok || ok && (c.R != tt.r || c.G != tt.g || c.B != tt.b) {
+			t.Errorf("parseLegacyColor(%q) = %v, %v", tt.in, c, ok)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+// Rule index and ancestor filter, which let the cascade skip most of the rules
+// without matching them, like browsers do
+
+// ruleIndex keeps selectors of a sheet by their pseudo-elements,
+// empty name is for elements themselves
+type ruleIndex map[string]*ruleBuckets
+
+// ruleBuckets bucket selectors by the rightmost compound:
+// by id if it has one, else by a class, else by tag, else they are universal.
+type ruleBuckets struct {
+	byID, byClass, byTag map[string][]indexedSelector
+	universal            []indexedSelector
+}
+
+// indexedSelector is a selector of the rule with the given index in its sheet
+type indexedSelector struct {
+	rule     int
+	selector *Selector
+	// ancestorHashes are hashes of ids, classes and tags which some ancestors
+	// must have for the selector to match
+	ancestorHashes []uint32
+}
+
+// maxAncestorHashes limits the hashes kept per selector, a few of them reject enough
+const maxAncestorHashes = 4
+
+func newRuleIndex(s *Stylesheet) ruleIndex {
+	// selectors with several classes go to the bucket of the rarest one
+	classCount := make(map[string]int)
+	for _, rule := range s.rules {
+		for _, sel := range rule.selectors {
+			for _, class := range sel.class {
+				classCount[class]++
+			}
+		}
+	}
+	index := make(ruleIndex)
+	for i, rule := range s.rules {
+		for _, sel := range rule.selectors {
+			buckets, ok := index[sel.pseudoElement]
+			if !ok {
+				buckets = &ruleBuckets{
+					byID:    make(map[string][]indexedSelector),
+					byClass: make(map[string][]indexedSelector),
+					byTag:   make(map[string][]indexedSelector),
+				}
+				index[sel.pseudoElement] = buckets
+			}
+			entry := indexedSelector{i, sel, ancestorHashes(sel)}
+			switch {
+			case sel.id != nil:
+				buckets.byID[*sel.id] = append(buckets.byID[*sel.id], entry)
+			case len(sel.class) > 0:
+				class := sel.class[0]
+				for _, c := range sel.class[1:] {
+					if classCount[c] < classCount[class] {
+						class = c
+					}
+				}
+				buckets.byClass[class] = append(buckets.byClass[class], entry)
+			case sel.tagName != nil:
+				buckets.byTag[*sel.tagName] = append(buckets.byTag[*sel.tagName], entry)
+			default:
+				buckets.universal = append(buckets.universal, entry)
+			}
+		}
+	}
+	return index
+}
+
+// getIndex returns index of the sheet, building it on the first use
+func (s *Stylesheet) getIndex() ruleIndex {
+	s.indexLock.Lock()
+	defer s.indexLock.Unlock()
+	if s.index == nil {
+		s.index = newRuleIndex(s)
+	}
+	return s.index
+}
+
+// resetIndex drops the index after rules of the sheet change
+func (s *Stylesheet) resetIndex() {
+	s.indexLock.Lock()
+	s.index = nil
+	s.indexLock.Unlock()
+}
+
+// candidates calls f for selectors of the pseudo-element which may match the element,
+// and which the filter of its ancestors doesn't reject. Each selector is visited once,
+// as it is only in one bucket.
+func (index ruleIndex) candidates(node *Node, pseudo string, filter *ancestorFilter, f func(entry *indexedSelector)) {
+	buckets, ok := index[pseudo]
+	if !ok {
+		return
+	}
+	visit := func(entries []indexedSelector) {
+		for i := range entries {
+			if filter.mayMatch(entries[i].ancestorHashes) {
+				f(&entries[i])
+			}
+		}
+	}
+	if id := node.GetID(); id != nil {
+		visit(buckets.byID[*id])
+	}
+	classes := node.ClassList()
+	for i, class := range classes {
+		if !containsString(classes[:i], class) {
+			visit(buckets.byClass[class])
+		}
+	}
+	visit(buckets.byTag[node.TagName()])
+	visit(buckets.universal)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ancestorHashes collects hashes of compounds which must be ancestors of the element:
+// those left of descendant and child combinators
+func ancestorHashes(sel *Selector) []uint32 {
+	hashes := []uint32{}
+	for s := sel; s.left != nil && len(hashes) < maxAncestorHashes; s = s.left {
+		if s.combinator != descendant && s.combinator != child {
+			continue
+		}
+		left := s.left
+		if left.id != nil {
+			hashes = append(hashes, hashKey('#', *left.id))
+		}
+		for _, class := range left.class {
+			hashes = append(hashes, hashKey('.', class))
+		}
+		if left.tagName != nil {
+			hashes = append(hashes, hashKey(' ', *left.tagName))
+		}
+	}
+	if len(hashes) > maxAncestorHashes {
+		hashes = hashes[:maxAncestorHashes]
+	}
+	return hashes
+}
+
+// hashKey is FNV-1a hash of the kind and the name, without allocations of hash/fnv
+func hashKey(kind byte, name string) uint32 {
+	const offset, prime = 2166136261, 16777619
+	h := uint32(offset)
+	h = (h ^ uint32(kind)) * prime
+	for i := 0; i < len(name); i++ {
+		h = (h ^ uint32(name[i])) * prime
+	}
+	return h
+}
+
+const ancestorFilterBits = 12
+
+// ancestorFilter is a counting Bloom filter of ids, classes and tags of the elements
+// which are ancestors of the current one. It may give false positives only,
+// so selectors are rejected if some of their ancestor hashes are missing.
+type ancestorFilter struct {
+	counters [1 << ancestorFilterBits]uint16
+}
+
+// elementHashes appends hashes of id, classes and tag of the element to hashes
+func elementHashes(node *Node, hashes []uint32) []uint32 {
+	hashes = append(hashes, hashKey(' ', node.TagName()))
+	if id := node.GetID(); id != nil {
+		hashes = append(hashes, hashKey('#', *id))
+	}
+	for _, class := range node.ClassList() {
+		hashes = append(hashes, hashKey('.', class))
+	}
+	return hashes
+}
+
+// positions are two counters of the hash, from its lower and upper bits
+func (f *ancestorFilter) positions(hash uint32) (uint32, uint32) {
+	const mask = 1<<ancestorFilterBits - 1
+	return hash & mask, (hash >> 16) & mask
+}
+
+// maxElementHashes is how many hashes of an element push and pop keep on the stack
+const maxElementHashes = 16
+
+func (f *ancestorFilter) push(node *Node) {
+	var buf [maxElementHashes]uint32
+	for _, hash := range elementHashes(node, buf[:0]) {
+		a, b := f.positions(hash)
+		f.counters[a]++
+		f.counters[b]++
+	}
+}
+
+func (f *ancestorFilter) pop(node *Node) {
+	var buf [maxElementHashes]uint32
+	for _, hash := range elementHashes(node, buf[:0]) {
+		a, b := f.positions(hash)
+		f.counters[a]--
+		f.counters[b]--
+	}
+}
+
+// mayMatch tells if all the hashes may be of ancestors, nil filter knows nothing
+func (f *ancestorFilter) mayMatch(hashes []uint32) bool {
+	if f == nil {
+		return true
+	}
+	for _, hash := range hashes {
+		a, b := f.positions(hash)
+		if f.counters[a] == 0 || f.counters[b] == 0 {
+			return false
+		}
+	}
+	return true
+}
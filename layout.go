@@ -13,6 +13,7 @@ import (
 	"image/color"
 	"io"
 	"strings"
+	"unicode"
 )
 
 type dimensions struct {
@@ -36,6 +37,12 @@ type layoutBox struct {
 	boxType    boxType
 	styledNode *styledNode
 	children   []*layoutBox
+	// styles of ::first-line and ::first-letter, which apply to this block
+	// or are passed down to its first child block
+	firstLine   propertyMap
+	firstLetter propertyMap
+	// fonts are those of the document, boxes get them from their parent when they are laid out
+	fonts fontRegistry
 }
 
 type boxType int
@@ -52,10 +59,6 @@ type bounds struct {
 	width, height int
 }
 
-var blockTags = []string{
-	"div", "p",
-}
-
 func (r rect) min() image.Point {
 	return image.Point{int(r.x), int(r.y)}
 }
@@ -93,7 +96,11 @@ func makeAnonymousBlockBox() *layoutBox {
 	return box
 }
 
+// nodesToBoxes builds layout tree, nil is returned for nodes without boxes
 func nodesToBoxes(node *styledNode) *layoutBox {
+	if node.displayType() == none {
+		return nil
+	}
 	childBoxes := []*layoutBox{}
 	isOnlyInline := true
 
@@ -139,6 +146,8 @@ func nodesToBoxes(node *styledNode) *layoutBox {
 		}
 	} else if isBlockElement(node) {
 		box.boxType = blockBox
+		box.firstLine = node.firstLine
+		box.firstLetter = node.firstLetter
 	} else {
 		box.boxType = inlineBox
 	}
@@ -195,22 +204,35 @@ func (box *layoutBox) calculateLineHeight() {
 }
 
 func (box *layoutBox) layoutChildren() {
+	box.passFirstLineStyles()
 	newChildren := []*layoutBox{}
+	firstLine := true
 	var lineBox *layoutBox
 	x := box.dimensions.content.x
 	y := box.dimensions.content.y
 	width := box.dimensions.content.width
 	for _, child := range box.children {
+		child.fonts = box.fonts
 		if child.boxType != blockBox {
 			if lineBox == nil {
 				lineBox = newLineBox(x, y, width)
 			}
-			if !lineBox.canAppendToLine(child) {
+			// the first line is measured with its styles, boxes which don't fit go to the next line without them
+			inline := child
+			if firstLine && box.firstLine != nil {
+				inline = withFirstLine(child, box.firstLine)
+			}
+			inline.layout(box.dimensions)
+			if len(lineBox.children) > 0 && !lineBox.canAppendToLine(inline) {
 				newChildren = box.appendLine(newChildren, lineBox)
 				lineBox = newLineBox(x, y, width)
+				if inline != child {
+					inline = child
+					inline.layout(box.dimensions)
+				}
+				firstLine = false
 			}
-			child.layout(box.dimensions)
-			lineBox.appendToLine(child)
+			lineBox.appendToLine(inline)
 		} else {
 			child.layout(box.dimensions)
 			newChildren = append(newChildren, child)
@@ -223,6 +245,124 @@ func (box *layoutBox) layoutChildren() {
 	box.children = newChildren
 }
 
+// passFirstLineStyles applies ::first-letter of the block to its first letter,
+// if the block starts with inline content. Otherwise the first line is
+// in the first child block, so ::first-line and ::first-letter go to it.
+// https://www.w3.org/TR/css-pseudo-4/#first-text-line
+func (box *layoutBox) passFirstLineStyles() {
+	if box.boxType != blockBox || len(box.children) == 0 {
+		return
+	}
+	first := box.children[0]
+	if first.boxType == blockBox {
+		first.firstLine = mergeProperties(box.firstLine, first.firstLine)
+		first.firstLetter = mergeProperties(box.firstLetter, first.firstLetter)
+		return
+	}
+	if box.firstLetter != nil {
+		box.splitFirstLetter(box.firstLetter)
+	}
+}
+
+// splitFirstLetter puts the first letter of the first text into ::first-letter box.
+// It returns true when the search is over: the text is found or some box precedes it.
+func (box *layoutBox) splitFirstLetter(values propertyMap) bool {
+	for i, child := range box.children {
+		switch child.boxType {
+		case textBox:
+			letter, rest := splitFirstLetter(child.styledNode.node.Data)
+			if letter == "" {
+				return true
+			}
+			boxes := []*layoutBox{newFirstLetterBox(child.styledNode, letter, values)}
+			if rest != "" {
+				restNode := *child.styledNode.node
+				restNode.Data = rest
+				boxes = append(boxes, &layoutBox{boxType: textBox, styledNode: &styledNode{node: &restNode, specifiedValues: child.styledNode.specifiedValues, computedValues: child.styledNode.computedValues}})
+			}
+			box.children = append(box.children[:i], append(boxes, box.children[i+1:]...)...)
+			return true
+		case inlineBox:
+			if child.styledNode.pseudo == "first-letter" || child.splitFirstLetter(values) {
+				return true
+			}
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// newFirstLetterBox makes inline ::first-letter box with the letter text inside,
+// values of the pseudo-element override those inherited by the text
+func newFirstLetterBox(text *styledNode, letter string, values propertyMap) *layoutBox {
+	pseudo := &styledNode{
+		node:            newPseudoElementNode(text.node.Parent, "first-letter"),
+		specifiedValues: values,
+		computedValues:  mergeProperties(text.computedValues, values),
+		pseudo:          "first-letter",
+	}
+	letterNode := &Node{NodeType: TextNode, Data: letter, Children: []*Node{}, Attributes: make(map[string]string), Parent: pseudo.node}
+	letterBox := &layoutBox{boxType: textBox, styledNode: &styledNode{node: letterNode, specifiedValues: propertyMap{}, computedValues: inheritedValues(pseudo.computedValues)}}
+	return &layoutBox{boxType: inlineBox, styledNode: pseudo, children: []*layoutBox{letterBox}}
+}
+
+// splitFirstLetter separates typographic letter unit: the first letter or digit
+// with punctuation around it, https://www.w3.org/TR/css-pseudo-4/#first-letter-pattern
+func splitFirstLetter(s string) (string, string) {
+	runes := []rune(s)
+	i := 0
+	for i < len(runes) && unicode.IsPunct(runes[i]) {
+		i++
+	}
+	if i == len(runes) || !unicode.IsLetter(runes[i]) && !unicode.IsNumber(runes[i]) {
+		return "", s
+	}
+	i++
+	for i < len(runes) && unicode.IsPunct(runes[i]) {
+		i++
+	}
+	return string(runes[:i]), string(runes[i:])
+}
+
+// withFirstLine returns copy of the box and its descendants styled as if they were
+// inside ::first-line, their own declared values win over it
+func withFirstLine(box *layoutBox, values propertyMap) *layoutBox {
+	copied := *box
+	if box.styledNode != nil {
+		styled := *box.styledNode
+		styled.specifiedValues = mergeProperties(values, box.styledNode.specifiedValues)
+		styled.computedValues = mergeProperties(box.styledNode.computedValues, nil)
+		for name, v := range values {
+			if _, own := box.styledNode.specifiedValues[name]; !own {
+				styled.computedValues[name] = v
+			}
+		}
+		copied.styledNode = &styled
+	}
+	copied.children = make([]*layoutBox, len(box.children))
+	for i, child := range box.children {
+		copied.children[i] = withFirstLine(child, values)
+	}
+	return &copied
+}
+
+// mergeProperties returns copy of base overridden by values of over,
+// nil if both are empty
+func mergeProperties(base, over propertyMap) propertyMap {
+	if len(base) == 0 && len(over) == 0 {
+		return nil
+	}
+	result := make(propertyMap)
+	for k, v := range base {
+		result[k] = v
+	}
+	for k, v := range over {
+		result[k] = v
+	}
+	return result
+}
+
 func (box *layoutBox) isBlock() bool {
 	return box.boxType == blockBox
 }
@@ -249,6 +389,7 @@ func (box *layoutBox) layout(containingBlock dimensions) {
 	if box.boxType == blockBox {
 		box.calculateWidth(containingBlock)
 		box.calculatePosition(containingBlock)
+		box.resolveContainerQueries()
 	}
 
 	box.layoutChildren()
@@ -258,13 +399,22 @@ func (box *layoutBox) layout(containingBlock dimensions) {
 			box.dimensions.content.width += child.dimensions.content.width
 		}
 		if box.boxType == textBox {
-			box.dimensions.content.width = float32(getStringWidth(box.styledNode.node.Data))
-			box.dimensions.content.height = float32(getFontHeight())
+			style := box.fontStyle()
+			box.dimensions.content.width = float32(getStringWidth(box.styledNode.node.Data, style))
+			box.dimensions.content.height = float32(getFontHeight(style))
 		}
 	}
 }
 
+// fontStyle returns the font style of the box text with fonts of the document
+func (box *layoutBox) fontStyle() fontStyle {
+	style := fontStyleOf(box.styledNode.computedValues)
+	style.fonts = box.fonts
+	return style
+}
+
 // a lot more simple than specified https://www.w3.org/TR/CSS2/visudet.html#Computing_widths_and_margins
+// percentages of margins and paddings, even vertical ones, are of the containing block width
 func (box *layoutBox) calculateWidth(containingBlock dimensions) {
 	node := box.styledNode
 	if node == nil {
@@ -276,28 +426,28 @@ func (box *layoutBox) calculateWidth(containingBlock dimensions) {
 	zero := Value{length: 0, valueType: Length}
 	d := &box.dimensions
 
-	marginLeft := node.lookupDouble("margin-left", "margin", zero)
-	marginRight := node.lookupDouble("margin-right", "margin", zero)
-	borderLeft := node.lookupDouble("border-left-width", "border-width", zero)
-	borderRight := node.lookupDouble("border-right-width", "border-width", zero)
-	paddingLeft := node.lookupDouble("padding-left", "padding", zero)
-	paddingRight := node.lookupDouble("padding-right", "padding", zero)
+	marginLeft := node.lookupOr("margin-left", zero)
+	marginRight := node.lookupOr("margin-right", zero)
+	borderLeft := node.lookupOr("border-left-width", zero)
+	borderRight := node.lookupOr("border-right-width", zero)
+	paddingLeft := node.lookupOr("padding-left", zero)
+	paddingRight := node.lookupOr("padding-right", zero)
 
-	d.margin.left = marginLeft.toPx()
-	d.margin.right = marginRight.toPx()
+	d.margin.left = marginLeft.toPxOf(containingBlock.content.width)
+	d.margin.right = marginRight.toPxOf(containingBlock.content.width)
 	d.border.left = borderLeft.toPx()
 	d.border.right = borderRight.toPx()
-	d.padding.left = paddingLeft.toPx()
-	d.padding.right = paddingRight.toPx()
+	d.padding.left = paddingLeft.toPxOf(containingBlock.content.width)
+	d.padding.right = paddingRight.toPxOf(containingBlock.content.width)
 
-	if width == auto {
+	if width.isKeyword("auto") {
 		sum := d.margin.left + d.margin.right
 		sum += d.border.left + d.border.right
 		sum += d.padding.left + d.padding.right
 
 		d.content.width = containingBlock.content.width - sum
 	} else {
-		d.content.width = width.toPx()
+		d.content.width = width.toPxOf(containingBlock.content.width)
 	}
 }
 
@@ -310,19 +460,19 @@ func (box *layoutBox) calculatePosition(containingBlock dimensions) {
 	zero := Value{length: 0, valueType: Length}
 	d := &box.dimensions
 
-	marginTop := node.lookupDouble("margin-top", "margin", zero)
-	marginBottom := node.lookupDouble("margin-bottom", "margin", zero)
-	borderTop := node.lookupDouble("border-top-width", "border-width", zero)
-	borderBottom := node.lookupDouble("border-bottom-width", "border-width", zero)
-	paddingTop := node.lookupDouble("padding-top", "padding", zero)
-	paddingBottom := node.lookupDouble("padding-bottom", "padding", zero)
+	marginTop := node.lookupOr("margin-top", zero)
+	marginBottom := node.lookupOr("margin-bottom", zero)
+	borderTop := node.lookupOr("border-top-width", zero)
+	borderBottom := node.lookupOr("border-bottom-width", zero)
+	paddingTop := node.lookupOr("padding-top", zero)
+	paddingBottom := node.lookupOr("padding-bottom", zero)
 
-	d.margin.top = marginTop.toPx()
-	d.margin.bottom = marginBottom.toPx()
+	d.margin.top = marginTop.toPxOf(containingBlock.content.width)
+	d.margin.bottom = marginBottom.toPxOf(containingBlock.content.width)
 	d.border.top = borderTop.toPx()
 	d.border.bottom = borderBottom.toPx()
-	d.padding.top = paddingTop.toPx()
-	d.padding.bottom = paddingBottom.toPx()
+	d.padding.top = paddingTop.toPxOf(containingBlock.content.width)
+	d.padding.bottom = paddingBottom.toPxOf(containingBlock.content.width)
 
 	d.content.x = containingBlock.content.x + d.margin.left + d.border.left + d.padding.left
 
@@ -349,17 +499,7 @@ func newColoredBox(r rect, c color.RGBA, children []*layoutBox) *layoutBox {
 //	We receive a box
 //	We need to know the height of this box. This available in the box
 func isBlockElement(node *styledNode) bool {
-	v, ok := node.specifiedValues["display"]
-	if ok {
-		return v.keyword == "block"
-	}
-
-	for _, v := range blockTags {
-		if v == node.node.TagName() {
-			return true
-		}
-	}
-	return false
+	return node.displayType() == block
 }
 
 // PrintLayoutTree prints the tree
@@ -5,6 +5,123 @@ import (
 	"testing"
 )
 
+func Test_splitFirstLetter(t *testing.T) {
+	tests := []struct {
+		in, letter, rest string
+	}{
+		{"Hello", "H", "ello"},
+		{"“Quote”", "“Q", "uote”"},
+		{"(1) item", "(1)", " item"},
+		{"!!!", "", "!!!"},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		letter, rest := splitFirstLetter(tt.in)
+		if letter != tt.letter || rest != tt.rest {
+			t.Errorf("splitFirstLetter(%q) = %q, %q", tt.in, letter, rest)
+		}
+	}
+}
+
+func TestFirstLineAndLetter(t *testing.T) {
+	layoutString := func(html, css string) *layoutBox {
+		sn := makeStyledNodeFromString(strings.NewReader(html), strings.NewReader(css))
+		box := nodesToBoxes(sn)
+		box.layoutRoot(800, 600)
+		return box
+	}
+	t.Run("drop cap", func(t *testing.T) {
+		box := layoutString(`<p>Once <em>upon</em></p>`, `p {display: block} p::first-letter {color: #ff0000}`)
+		line := box.children[0].children[0]
+		letter := line.children[0]
+		if !(letter.tag() == "::first-letter" && letter.styledNode.specifiedValues["color"].color.R == 255 &&
+			boxText(letter) == "O" && line.children[1].styledNode.node.Data == "nce") {
+			t.Errorf("first letter should be split")
+		}
+	})
+	t.Run("first letter inside inline element", func(t *testing.T) {
+		box := layoutString(`<p><em>upon</em></p>`, `p {display: block} p::first-letter {color: #ff0000}`)
+		em := box.children[0].children[0].children[0]
+		if !(em.tag() == "em" && boxText(findBox(em, "::first-letter")) == "u") {
+			t.Errorf("first letter should be inside em")
+		}
+	})
+	t.Run("first line goes to the first child block", func(t *testing.T) {
+		box := layoutString(`<div><p>text</p></div>`,
+			`div, p {display: block} div::first-line {color: #ff0000} div::first-letter {color: #0000ff}`)
+		line := box.children[0].children[0].children[0]
+		if boxText(findBox(line, "::first-letter")) != "t" {
+			t.Fatal("first letter should be in the first child block")
+		}
+		if c := line.children[1].styledNode.specifiedValues["color"].color; c.R != 255 {
+			t.Error("text of the first line should be styled", c)
+		}
+	})
+	t.Run("own values win over first line", func(t *testing.T) {
+		box := layoutString(`<p><em>a</em>b</p>`, `p {display: block} em {color: #00ff00} p::first-line {color: #ff0000; margin: 0}`)
+		line := box.children[0].children[0]
+		em := line.children[0].styledNode.specifiedValues
+		if em["color"].color.G != 255 || !pmapContainsKey(em, "margin") {
+			t.Error("wrong first line values of em", em)
+		}
+		if line.children[1].styledNode.specifiedValues["color"].color.R != 255 {
+			t.Error("text should be styled")
+		}
+	})
+	t.Run("first line is broken with its font size", func(t *testing.T) {
+		text := func(s string) *layoutBox {
+			node := &Node{NodeType: TextNode, Data: s}
+			return &layoutBox{boxType: textBox, styledNode: &styledNode{node: node, specifiedValues: propertyMap{}, computedValues: propertyMap{"font-size": pxValue(16)}}}
+		}
+		layoutLines := func(firstLine propertyMap) []*layoutBox {
+			box := &layoutBox{boxType: blockBox, firstLine: firstLine, children: []*layoutBox{text("aaaaa"), text("bbbbb")}}
+			box.dimensions.content.width = 100
+			box.layoutChildren()
+			return box.children
+		}
+		if lines := layoutLines(nil); len(lines) != 1 {
+			t.Fatalf("got %d lines, want both texts on one line", len(lines))
+		}
+		lines := layoutLines(propertyMap{"font-size": pxValue(32)})
+		if len(lines) != 2 || len(lines[0].children) != 1 || len(lines[1].children) != 1 {
+			t.Fatalf("got %d lines, want one text on each line of two", len(lines))
+		}
+		first, second := lines[0].children[0], lines[1].children[0]
+		if first.styledNode.computedValues["font-size"].toPx() != 32 || second.styledNode.computedValues["font-size"].toPx() != 16 {
+			t.Error("only the first line should have its font size")
+		}
+		if first.dimensions.content.width <= second.dimensions.content.width {
+			t.Errorf("first line text width %v should be more than %v", first.dimensions.content.width, second.dimensions.content.width)
+		}
+	})
+}
+
+func boxText(box *layoutBox) string {
+	if box == nil {
+		return ""
+	}
+	if box.boxType == textBox {
+		return box.styledNode.node.Data
+	}
+	s := ""
+	for _, c := range box.children {
+		s += boxText(c)
+	}
+	return s
+}
+
+func findBox(box *layoutBox, tag string) *layoutBox {
+	if box.tag() == tag {
+		return box
+	}
+	for _, c := range box.children {
+		if found := findBox(c, tag); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
 func TestNodesToBoxes(t *testing.T) {
 	type args struct {
 		node *styledNode
@@ -85,6 +202,16 @@ func TestNodesToBoxes(t *testing.T) {
 		}
 	})
 
+	t.Run("generated boxes", func(t *testing.T) {
+		css := `p {display: block} p::before {content: "a"} p::after {content: "b"}`
+		sn := makeStyledNodeFromString(strings.NewReader("<p>1</p>"), strings.NewReader(css))
+		p := nodesToBoxes(sn).children[0]
+		if !(len(p.children) == 3 && p.children[0].tag() == "::before" && p.children[2].tag() == "::after" &&
+			p.children[0].children[0].boxType == textBox) {
+			t.Errorf("should have generated boxes around text")
+		}
+	})
+
 	t.Run("", func(t *testing.T) {
 		var html = "<span>3</span><p>1</p><span>2</span>"
 		sn := makeStyledNodeFromString(strings.NewReader(html), cssR)
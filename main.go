@@ -131,7 +131,14 @@ var layout = newColoredBox(rect{20, 20, 300, 200}, red, []*layoutBox{
 	newColoredBox(rect{100, 300, 10, 10}, green, nil),
 })
 
-func makeStyledNodeFromString(htmlReader io.Reader, cssReader io.Reader) *styledNode {
+// makeStyledNodeFromString styles the document with the user agent stylesheet,
+// its author stylesheet and optional user stylesheets, like high-contrast overrides
+func makeStyledNodeFromString(htmlReader io.Reader, cssReader io.Reader, userStyles ...*Stylesheet) *styledNode {
+	return makeStyledNodeForMedia(htmlReader, cssReader, defaultMediaEnvironment(), userStyles...)
+}
+
+// makeStyledNodeForMedia is makeStyledNodeFromString, which evaluates @media rules in the environment
+func makeStyledNodeForMedia(htmlReader io.Reader, cssReader io.Reader, media *mediaEnvironment, userStyles ...*Stylesheet) *styledNode {
 	n, err := parseHTMLWrapped(htmlReader)
 	if err != nil {
 		log.Fatalln("HTML ERROR.", err)
@@ -140,14 +147,24 @@ func makeStyledNodeFromString(htmlReader io.Reader, cssReader io.Reader) *styled
 	if err != nil {
 		log.Fatalln("CSS ERROR.", err)
 	}
-	st := styleTree(n, s)
+	sheets := append([]*Stylesheet{UserAgentStylesheet()}, userStyles...)
+	st := styleTreeMedia(n, media, append(sheets, s)...)
 	return st
 }
 
-func drawHTMLAndCSS(htmlReader io.Reader, cssReader io.Reader, width int, height int) *image.RGBA {
-	st := makeStyledNodeFromString(htmlReader, cssReader)
+func drawHTMLAndCSS(htmlReader io.Reader, cssReader io.Reader, width int, height int, userStyles ...*Stylesheet) *image.RGBA {
+	return drawHTMLAndCSSWithOptions(htmlReader, cssReader, width, height, DefaultRenderOptions, userStyles...)
+}
+
+// drawHTMLAndCSSWithOptions draws the document, evaluating @media rules
+// for the viewport of width and height and the options
+func drawHTMLAndCSSWithOptions(htmlReader io.Reader, cssReader io.Reader, width int, height int, options RenderOptions, userStyles ...*Stylesheet) *image.RGBA {
+	st := makeStyledNodeForMedia(htmlReader, cssReader, newMediaEnvironment(width, height, options), userStyles...)
 	r := nodesToBoxes(st)
-	fmt.Print(r.String())
+	if r != nil {
+		r.fonts = documentFonts(userStyles)
+		fmt.Print(r.String())
+	}
 	return layoutAndDraw(r, width, height)
 }
 
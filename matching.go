@@ -1,7 +1,10 @@
 package main
 
 import (
+	"bufio"
 	"sort"
+	"strings"
+	"sync"
 )
 
 type propertyMap map[string]Value
@@ -9,7 +12,22 @@ type propertyMap map[string]Value
 type styledNode struct {
 	node            *Node
 	specifiedValues propertyMap
-	children        []*styledNode
+	// computedValues are specified values resolved against the parent,
+	// they have all properties of the registry
+	computedValues propertyMap
+	children       []*styledNode
+	// pseudo is the name of pseudo-element if the node is generated for it
+	pseudo string
+	// firstLine and firstLetter are computed values of properties declared
+	// for ::first-line and ::first-letter, nil if no rule matches
+	firstLine   propertyMap
+	firstLetter propertyMap
+	// restyle marks what StyledDocument must style again,
+	// dirtyDescendants is set if some descendant is marked
+	restyle          restyleHint
+	dirtyDescendants bool
+	// container is set for query containers, if the sheets have @container rules
+	container *queryContainer
 }
 
 type displayType int
@@ -20,26 +38,103 @@ const (
 	none
 )
 
+// blockDisplays are display values which make block-level boxes,
+// tables and list items are laid out as plain blocks
+var blockDisplays = map[string]bool{
+	"block": true, "flow-root": true, "list-item": true, "flex": true, "grid": true,
+	"table": true, "table-caption": true, "table-row-group": true, "table-header-group": true,
+	"table-footer-group": true, "table-row": true, "table-cell": true,
+	"table-column-group": true, "table-column": true,
+}
+
+// displayType is inline for elements without display, as it is its initial value
 func (n *styledNode) displayType() displayType {
 	if n.node.NodeType == ElementNode {
 		v, ok := n.lookup("display")
-		if ok {
-			switch v.keyword {
-			case "block":
-				return block
-			case "inline":
-				return inline
-			case "none":
+		if ok && v.valueType == Keyword {
+			if v.keyword == "none" {
 				return none
+			} else if blockDisplays[v.keyword] {
+				return block
 			}
 		}
-		return block
 	}
 	return inline
 }
 
+// matchContext keeps caches which live during one styling pass.
+// nil context is valid and caches nothing.
+type matchContext struct {
+	// has holds results of :has() arguments by anchor element
+	has selectorCache
+	// subtree holds whether some descendant of element matches a compound selector
+	subtree selectorCache
+}
+
+// selectorCache keeps results of selector matching by node, nil cache keeps nothing
+type selectorCache map[*Node]map[*Selector]bool
+
+func newMatchContext() *matchContext {
+	return &matchContext{
+		has:     make(selectorCache),
+		subtree: make(selectorCache),
+	}
+}
+
 func matches(node *Node, selector *Selector) bool {
-	if node.NodeType == TextNode {
+	var ctx *matchContext
+	return ctx.matches(node, selector)
+}
+
+// matches checks selector from right to left:
+// the rightmost compound against the node, then the rest against
+// ancestors or preceding siblings, depending on combinator
+func (ctx *matchContext) matches(node *Node, selector *Selector) bool {
+	return ctx.matchesRelative(node, selector, nil)
+}
+
+// matchesRelative matches selector which leftmost compound is related
+// to anchor by its combinator, like "> img" in :has(> img).
+// Without anchor it is the usual matching.
+func (ctx *matchContext) matchesRelative(node *Node, selector *Selector, anchor *Node) bool {
+	if !ctx.matchesCompound(node, selector) {
+		return false
+	}
+	if selector.left == nil && anchor == nil {
+		return true
+	}
+	matchesLeft := func(n *Node) bool {
+		if selector.left == nil {
+			return n == anchor
+		}
+		return ctx.matchesRelative(n, selector.left, anchor)
+	}
+
+	switch selector.combinator {
+	case descendant:
+		for p := node.ParentElement(); p != nil; p = p.ParentElement() {
+			if matchesLeft(p) {
+				return true
+			}
+		}
+	case child:
+		p := node.ParentElement()
+		return p != nil && matchesLeft(p)
+	case adjacentSibling:
+		s := node.PreviousElementSibling()
+		return s != nil && matchesLeft(s)
+	case generalSibling:
+		for s := node.PreviousElementSibling(); s != nil; s = s.PreviousElementSibling() {
+			if matchesLeft(s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (ctx *matchContext) matchesCompound(node *Node, selector *Selector) bool {
+	if node.NodeType != ElementNode {
 		return false
 	}
 
@@ -48,16 +143,24 @@ func matches(node *Node, selector *Selector) bool {
 	}
 
 	nodeID := node.GetID()
-	if selector.id != nil && nodeID != nil && *selector.id != *nodeID {
+	if selector.id != nil && (nodeID == nil || *selector.id != *nodeID) {
 		return false
 	}
 
-	if node.Class() == nil {
-		return true
+	for _, class := range selector.class {
+		if !node.HasClass(class) {
+			return false
+		}
 	}
 
-	for _, class := range selector.class {
-		if class != *node.Class() {
+	for _, attr := range selector.attrs {
+		if !matchesAttr(node, attr) {
+			return false
+		}
+	}
+
+	for _, pseudo := range selector.pseudo {
+		if !ctx.matchesPseudoClass(node, pseudo) {
 			return false
 		}
 	}
@@ -65,62 +168,692 @@ func matches(node *Node, selector *Selector) bool {
 	return true
 }
 
-func matchRule(node *Node, rule *Rule) bool {
-	for _, sel := range rule.selectors {
-		if matches(node, sel) {
+func (ctx *matchContext) matchesAny(node *Node, selectors []*Selector) bool {
+	for _, sel := range selectors {
+		if ctx.matches(node, sel) {
 			return true
 		}
 	}
 	return false
 }
 
-func matchRules(node *Node, style *Stylesheet) propertyMap {
-	pmap := make(propertyMap)
-	if node.NodeType != ElementNode {
-		return pmap
+// matchesPseudoClass checks tree-structural and logical pseudo-classes,
+// https://www.w3.org/TR/selectors-4/#structural-pseudos
+func (ctx *matchContext) matchesPseudoClass(node *Node, pseudo pseudoClass) bool {
+	anyElement := func(*Node) bool { return true }
+	sameType := func(n *Node) bool { return n.TagName() == node.TagName() }
+	switch pseudo.name {
+	case "root":
+		return node.ParentElement() == nil
+	case "empty":
+		for _, child := range node.Children {
+			if child.NodeType == ElementNode || child.Data != "" {
+				return false
+			}
+		}
+		return true
+	case "first-child":
+		return siblingPosition(node, anyElement, false) == 1
+	case "last-child":
+		return siblingPosition(node, anyElement, true) == 1
+	case "only-child":
+		return siblingPosition(node, anyElement, false) == 1 && siblingPosition(node, anyElement, true) == 1
+	case "first-of-type":
+		return siblingPosition(node, sameType, false) == 1
+	case "last-of-type":
+		return siblingPosition(node, sameType, true) == 1
+	case "only-of-type":
+		return siblingPosition(node, sameType, false) == 1 && siblingPosition(node, sameType, true) == 1
+	case "nth-child", "nth-last-child":
+		filter := anyElement
+		if pseudo.selectors != nil {
+			if !ctx.matchesAny(node, pseudo.selectors) {
+				return false
+			}
+			filter = func(n *Node) bool { return ctx.matchesAny(n, pseudo.selectors) }
+		}
+		return nthMatches(pseudo.a, pseudo.b, siblingPosition(node, filter, pseudo.name == "nth-last-child"))
+	case "nth-of-type":
+		return nthMatches(pseudo.a, pseudo.b, siblingPosition(node, sameType, false))
+	case "nth-last-of-type":
+		return nthMatches(pseudo.a, pseudo.b, siblingPosition(node, sameType, true))
+	case "not":
+		return !ctx.matchesAny(node, pseudo.selectors)
+	case "is", "where":
+		return ctx.matchesAny(node, pseudo.selectors)
+	case "has":
+		for _, sel := range pseudo.selectors {
+			if ctx.hasRelative(node, sel) {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+// hasRelative tells if some element matches relative selector anchored at the node.
+// Results are cached by anchor, and single compound arguments like :has(img)
+// reuse results for children subtrees, so the whole document is visited once.
+func (ctx *matchContext) hasRelative(anchor *Node, sel *Selector) bool {
+	if result, ok := ctx.hasCache().lookup(anchor, sel); ok {
+		return result
+	}
+	result := false
+	if sel.left == nil && sel.combinator == descendant {
+		result = ctx.subtreeMatches(anchor, sel)
+	} else {
+		result = visitRelativeCandidates(anchor, sel, func(candidate *Node) bool {
+			return ctx.matchesRelative(candidate, sel, anchor)
+		})
 	}
-	for _, rule := range style.rules {
-		if matchRule(node, rule) {
-			for _, decl := range rule.declarators {
-				pmap[decl.name] = decl.value
+	ctx.hasCache().store(anchor, sel, result)
+	return result
+}
+
+// subtreeMatches tells if some descendant of the node matches compound selector
+func (ctx *matchContext) subtreeMatches(node *Node, sel *Selector) bool {
+	if result, ok := ctx.subtreeCache().lookup(node, sel); ok {
+		return result
+	}
+	result := false
+	for _, child := range node.Children {
+		if child.NodeType == ElementNode && (ctx.matchesCompound(child, sel) || ctx.subtreeMatches(child, sel)) {
+			result = true
+			break
+		}
+	}
+	ctx.subtreeCache().store(node, sel, result)
+	return result
+}
+
+func (ctx *matchContext) hasCache() selectorCache {
+	if ctx == nil {
+		return nil
+	}
+	return ctx.has
+}
+
+func (ctx *matchContext) subtreeCache() selectorCache {
+	if ctx == nil {
+		return nil
+	}
+	return ctx.subtree
+}
+
+func (c selectorCache) lookup(node *Node, sel *Selector) (bool, bool) {
+	result, ok := c[node][sel]
+	return result, ok
+}
+
+func (c selectorCache) store(node *Node, sel *Selector, result bool) {
+	if c == nil {
+		return
+	}
+	if c[node] == nil {
+		c[node] = make(map[*Selector]bool)
+	}
+	c[node][sel] = result
+}
+
+// visitRelativeCandidates visits elements which may match relative selector anchored at the node,
+// until visit returns true, and tells if it did. Candidates are children for >,
+// the sibling as many elements after as there are compounds for +, and following
+// siblings for ~ or if + is followed by ~. Selectors, which go deeper with > or space,
+// take descendants of those instead.
+func visitRelativeCandidates(anchor *Node, sel *Selector, visit func(*Node) bool) bool {
+	leftmost := sel
+	goesDeeper, anySibling := false, false
+	distance := 1
+	for ; leftmost.left != nil; leftmost = leftmost.left {
+		switch leftmost.combinator {
+		case descendant, child:
+			goesDeeper = true
+		case generalSibling:
+			anySibling = true
+		case adjacentSibling:
+			distance++
+		}
+	}
+	switch leftmost.combinator {
+	case descendant:
+		return visitDescendants(anchor, visit)
+	case child:
+		for _, c := range anchor.Children {
+			if c.NodeType != ElementNode {
+				continue
+			}
+			if visit(c) || goesDeeper && visitDescendants(c, visit) {
+				return true
+			}
+		}
+	case adjacentSibling, generalSibling:
+		if leftmost.combinator == adjacentSibling && !goesDeeper && !anySibling {
+			s := anchor
+			for i := 0; i < distance && s != nil; i++ {
+				s = s.NextElementSibling()
+			}
+			return s != nil && visit(s)
+		}
+		for s := anchor.NextElementSibling(); s != nil; s = s.NextElementSibling() {
+			if visit(s) || goesDeeper && visitDescendants(s, visit) {
+				return true
 			}
 		}
 	}
-	return pmap
+	return false
+}
+
+// visitDescendants visits descendant elements of the node in document order,
+// until visit returns true, and tells if it did
+func visitDescendants(node *Node, visit func(*Node) bool) bool {
+	for _, c := range node.Children {
+		if c.NodeType == ElementNode && (visit(c) || visitDescendants(c, visit)) {
+			return true
+		}
+	}
+	return false
+}
+
+// siblingPosition returns 1-based position of the node among element siblings
+// accepted by filter, counting from the end if fromEnd is set
+func siblingPosition(node *Node, filter func(*Node) bool, fromEnd bool) int {
+	if node.Parent == nil {
+		return 1
+	}
+	siblings := node.Parent.Children
+	pos := 0
+	for i := range siblings {
+		sibling := siblings[i]
+		if fromEnd {
+			sibling = siblings[len(siblings)-1-i]
+		}
+		if sibling.NodeType != ElementNode || sibling != node && !filter(sibling) {
+			continue
+		}
+		pos++
+		if sibling == node {
+			return pos
+		}
+	}
+	return pos
+}
+
+// nthMatches tells if there is n >= 0 such that an+b equals pos
+func nthMatches(a, b, pos int) bool {
+	if a == 0 {
+		return pos == b
+	}
+	diff := pos - b
+	return diff/a >= 0 && diff%a == 0
+}
+
+// caseInsensitiveAttrs are attributes which values are compared case-insensitively by default,
+// https://html.spec.whatwg.org/multipage/semantics-other.html#case-sensitivity-of-selectors
+var caseInsensitiveAttrs = map[string]bool{
+	"accept": true, "accept-charset": true, "align": true, "alink": true, "axis": true,
+	"bgcolor": true, "charset": true, "checked": true, "clear": true, "codetype": true,
+	"color": true, "compact": true, "declare": true, "defer": true, "dir": true,
+	"direction": true, "disabled": true, "enctype": true, "face": true, "frame": true,
+	"hreflang": true, "http-equiv": true, "lang": true, "language": true, "link": true,
+	"media": true, "method": true, "multiple": true, "nohref": true, "noresize": true,
+	"noshade": true, "nowrap": true, "readonly": true, "rel": true, "rev": true,
+	"rules": true, "scope": true, "scrolling": true, "selected": true, "shape": true,
+	"target": true, "text": true, "type": true, "valign": true, "valuetype": true,
+	"vlink": true,
+}
+
+// matchesAttr checks attribute selector, https://www.w3.org/TR/selectors-4/#attribute-selectors
+func matchesAttr(node *Node, attr attrSelector) bool {
+	actual, ok := node.Attributes[attr.name]
+	if !ok {
+		return false
+	}
+	expected := attr.value
+	if attr.caseInsensitive || !attr.caseSensitive && caseInsensitiveAttrs[attr.name] {
+		actual = strings.ToLower(actual)
+		expected = strings.ToLower(expected)
+	}
+
+	switch attr.op {
+	case "":
+		return true
+	case "=":
+		return actual == expected
+	case "~=":
+		for _, word := range strings.Fields(actual) {
+			if expected != "" && word == expected {
+				return true
+			}
+		}
+		return false
+	case "|=":
+		return actual == expected || strings.HasPrefix(actual, expected+"-")
+	case "^=":
+		return expected != "" && strings.HasPrefix(actual, expected)
+	case "$=":
+		return expected != "" && strings.HasSuffix(actual, expected)
+	case "*=":
+		return expected != "" && strings.Contains(actual, expected)
+	}
+	return false
 }
 
-func styleTree(node *Node, style *Stylesheet) *styledNode {
-	children := []*styledNode{}
-	sort.Slice(style.rules, func(i, j int) bool {
-		return compareSpecificity(style.rules[i].selectors, style.rules[j].selectors) < 0
+// matchingRules returns rules of the sheet which match the node, or its pseudo-element
+// if pseudo is not empty, with the highest specificity among their matching selectors.
+// Only candidates of the rule index are matched, those rejected by filter of ancestors
+// are skipped, nil filter rejects nothing. Rules in @media, which doesn't match media,
+// and in @container, which doesn't match containers of the media, are skipped.
+func (ctx *matchContext) matchingRules(node *Node, pseudo string, sheet *Stylesheet, media *mediaEnvironment, filter *ancestorFilter) map[int]specificity {
+	matched := make(map[int]specificity)
+	sheet.getIndex().candidates(node, pseudo, filter, func(entry *indexedSelector) {
+		sel := entry.selector
+		if !sheet.rules[entry.rule].matchesMedia(media) ||
+			!sheet.rules[entry.rule].matchesContainers(node, pseudo, media.containers) || !ctx.matches(node, sel) {
+			return
+		}
+		s := sel.specificity()
+		if spec, ok := matched[entry.rule]; !ok || compareSpecificity(s, spec) > 0 {
+			matched[entry.rule] = s
+		}
 	})
+	return matched
+}
+
+// matchedDeclaration is a declaration of a rule which matched the node
+type matchedDeclaration struct {
+	decl        *Declarator
+	origin      Origin
+	level       int
+	specificity specificity
+	// inline is set for declarations of style attribute,
+	// they win over any selector of the same level
+	inline bool
+	// position of declaration in the document: sheet, rule and declaration indexes
+	position [3]int
+}
+
+// lessPrecedence tells if m loses to other in the cascade
+func (m *matchedDeclaration) lessPrecedence(other *matchedDeclaration) bool {
+	if m.level != other.level {
+		return m.level < other.level
+	}
+	if m.inline != other.inline {
+		return other.inline
+	}
+	if c := compareSpecificity(m.specificity, other.specificity); c != 0 {
+		return c < 0
+	}
+	for i := range m.position {
+		if m.position[i] != other.position[i] {
+			return m.position[i] < other.position[i]
+		}
+	}
+	return false
+}
+
+// cascadeLevel gives precedence of declaration, according to
+// https://www.w3.org/TR/css-cascade-4/#cascade-origin
+// normal declarations of user agent, user, author,
+// then important declarations in the reversed order
+func cascadeLevel(origin Origin, important bool) int {
+	level := 0
+	switch origin {
+	case UserAgentOrigin:
+		level = 0
+	case UserOrigin:
+		level = 1
+	case AuthorOrigin:
+		level = 2
+	}
+	if important {
+		level = 5 - level
+	}
+	return level
+}
+
+// cascade returns matched declarations sorted from lowest to highest precedence:
+// by origin and importance, then by specificity, then by source order.
+// Presentational hints are of author origin and precede its stylesheets,
+// declarations of style attribute are of author origin and win over its selectors.
+func (ctx *matchContext) cascade(node *Node, pseudo string, sheets []*Stylesheet, media *mediaEnvironment, filter *ancestorFilter) []matchedDeclaration {
+	matched := []matchedDeclaration{}
+	if pseudo == "" {
+		for declIndex, decl := range presentationalHints(node) {
+			matched = append(matched, matchedDeclaration{
+				decl:     decl,
+				origin:   AuthorOrigin,
+				level:    cascadeLevel(AuthorOrigin, false),
+				position: [3]int{-1, 0, declIndex},
+			})
+		}
+	}
+	for sheetIndex, style := range sheets {
+		for ruleIndex, spec := range ctx.matchingRules(node, pseudo, style, media, filter) {
+			for declIndex, decl := range style.rules[ruleIndex].declarators {
+				matched = append(matched, matchedDeclaration{
+					decl:        decl,
+					origin:      style.origin,
+					level:       cascadeLevel(style.origin, decl.important),
+					specificity: spec,
+					position:    [3]int{sheetIndex, ruleIndex, declIndex},
+				})
+			}
+		}
+	}
+	if style, ok := node.Attributes["style"]; ok && pseudo == "" {
+		// invalid declarations are dropped, like browsers do
+		decls, _ := parseDeclarationList(bufio.NewReader(strings.NewReader(style)))
+		for declIndex, decl := range decls {
+			matched = append(matched, matchedDeclaration{
+				decl:     decl,
+				origin:   AuthorOrigin,
+				level:    cascadeLevel(AuthorOrigin, decl.important),
+				inline:   true,
+				position: [3]int{len(sheets), 0, declIndex},
+			})
+		}
+	}
+	if len(matched) > 1 {
+		sort.Sort(byPrecedence(matched))
+	}
+	return matched
+}
+
+// byPrecedence sorts matched declarations by lessPrecedence
+type byPrecedence []matchedDeclaration
+
+func (m byPrecedence) Len() int           { return len(m) }
+func (m byPrecedence) Less(i, j int) bool { return m[i].lessPrecedence(&m[j]) }
+func (m byPrecedence) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+
+// styler holds state of one styling pass over the document
+type styler struct {
+	sheets []*Stylesheet
+	media  *mediaEnvironment
+	ctx    *matchContext
+	// rootFontSize is font size of the document element, for rem units
+	rootFontSize float32
+	// ancestors has ancestors of the element being styled
+	ancestors *ancestorFilter
+	// shareStyles enables sharing of style between siblings,
+	// revalidation has selectors which must match both of them
+	shareStyles  bool
+	revalidation ruleIndex
+	// pool limits goroutines of parallel styling, it is nil for sequential one.
+	// Subtrees of at least threshold nodes by sizes go to other goroutines.
+	pool      chan struct{}
+	sizes     map[*Node]int
+	threshold int
+	// containerRules is set if the sheets have @container rules, then query containers
+	// are registered in media
+	containerRules bool
+	// styles has computed values to share between nodes
+	styles *styleCache
+	// pseudoElements are those, which some selectors of the sheets have
+	pseudoElements map[string]bool
+}
+
+func newStyler(sheets []*Stylesheet) *styler {
+	sheets = withImports(sheets)
+	pseudoElements := make(map[string]bool)
+	for _, sheet := range sheets {
+		for pseudo := range sheet.getIndex() {
+			pseudoElements[pseudo] = true
+		}
+	}
+	return &styler{
+		sheets:         sheets,
+		media:          defaultMediaEnvironment(),
+		ctx:            newMatchContext(),
+		rootFontSize:   defaultFontSize,
+		ancestors:      new(ancestorFilter),
+		shareStyles:    true,
+		revalidation:   revalidationIndex(sheets),
+		containerRules: hasContainerRules(sheets),
+		styles:         newStyleCache(),
+		pseudoElements: pseudoElements,
+	}
+}
+
+// matchRules returns specified values of the node, revert keyword is resolved here
+// as it needs the cascade
+func (s *styler) matchRules(node *Node, pseudo string) propertyMap {
+	if node.NodeType != ElementNode {
+		return make(propertyMap)
+	}
+	return specifiedValues(s.ctx.cascade(node, pseudo, s.sheets, s.media, s.ancestors))
+}
+
+// specifiedValues returns values of the cascade winners of the matched declarations
+func specifiedValues(matched []matchedDeclaration) propertyMap {
+	matched = expandShorthands(matched)
+	pmap := make(propertyMap, len(matched))
+	for i := range matched {
+		if w := winningIndex(matched, i); w >= 0 {
+			pmap[matched[i].decl.name] = matched[w].decl.value
+		} else {
+			delete(pmap, matched[i].decl.name)
+		}
+	}
+	return pmap
+}
+
+// winningIndex returns index of the declaration, which gives value of i-th one.
+// For revert keyword it is the declaration the property would have without
+// declarations of its origin, https://www.w3.org/TR/css-cascade-4/#default,
+// -1 if there is none, and the property is unset.
+func winningIndex(matched []matchedDeclaration, i int) int {
+	m := &matched[i]
+	if !m.decl.value.isKeyword("revert") {
+		return i
+	}
+	rank := cascadeLevel(m.origin, false)
+	for j := i - 1; j >= 0; j-- {
+		if matched[j].decl.name == m.decl.name && cascadeLevel(matched[j].origin, false) < rank {
+			return winningIndex(matched, j)
+		}
+	}
+	return -1
+}
+
+// styleTree applies stylesheets to the node and its children,
+// sheets given later win over earlier ones of the same origin.
+// Boxes of ::before and ::after become the first and the last children
+// of their element, with the content generated in document order.
+// @media rules are evaluated for the default viewport and render options,
+// which tell also if it is styled concurrently.
+func styleTree(node *Node, sheets ...*Stylesheet) *styledNode {
+	return styleTreeMedia(node, defaultMediaEnvironment(), sheets...)
+}
+
+// styleTreeMedia is styleTree, which evaluates @media rules in the given environment.
+// With more than one Workers of its options, subtrees of at least ParallelThreshold nodes
+// are styled concurrently by up to Workers goroutines. The result is the same.
+func styleTreeMedia(node *Node, media *mediaEnvironment, sheets ...*Stylesheet) *styledNode {
+	s := newStyler(sheets)
+	s.media = media
+	if media.Workers > 1 {
+		s.pool = make(chan struct{}, media.Workers-1)
+		s.sizes = make(map[*Node]int)
+		s.threshold = media.ParallelThreshold
+		countNodes(node, s.sizes)
+	}
+	root := s.styleTree(node, nil)
+	generateContent(root)
+	return root
+}
+
+// countNodes stores sizes of the node subtree and its subtrees
+func countNodes(node *Node, sizes map[*Node]int) int {
+	size := 1
 	for _, child := range node.Children {
-		children = append(children, styleTree(child, style))
+		size += countNodes(child, sizes)
 	}
-	return &styledNode{
+	sizes[node] = size
+	return size
+}
+
+// spawn styles the node subtree in another goroutine if it is big enough
+// and the pool has room, result is set when wg is done.
+// Each goroutine has its own caches and filter of ancestors, the rest of styler is read only,
+// so with @container rules, which register query containers, styling is sequential.
+func (s *styler) spawn(node *Node, parent propertyMap, result **styledNode, wg *sync.WaitGroup) bool {
+	if s.pool == nil || s.containerRules || s.sizes[node] < s.threshold || node.ParentElement() == nil {
+		return false
+	}
+	select {
+	case s.pool <- struct{}{}:
+	default:
+		return false
+	}
+	task := *s
+	task.ctx = newMatchContext()
+	task.styles = newStyleCache()
+	ancestors := *s.ancestors
+	task.ancestors = &ancestors
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		*result = task.styleTree(node, parent)
+		<-s.pool
+	}()
+	return true
+}
+
+// styleTree styles the node and its subtree, parent is computed values
+// of the parent node, nil for the root
+func (s *styler) styleTree(node *Node, parent propertyMap) *styledNode {
+	styled, _ := s.styleNode(node, parent)
+	return styled
+}
+
+// styleNode is styleTree, which returns also the sharing cache of the node children
+func (s *styler) styleNode(node *Node, parent propertyMap) (*styledNode, sharingCache) {
+	specified, computed := s.computeStyle(node, parent)
+	if node.NodeType == ElementNode && node.ParentElement() == nil {
+		s.rootFontSize = computed["font-size"].toPx()
+	}
+	styled := &styledNode{
 		node:            node,
-		specifiedValues: matchRules(node, style),
-		children:        children,
+		specifiedValues: specified,
+		computedValues:  computed,
+		children:        []*styledNode{},
+		firstLine:       s.matchPseudoRules(node, "first-line", computed),
+		firstLetter:     s.matchPseudoRules(node, "first-letter", computed),
+	}
+	s.updateContainer(styled)
+	return styled, s.styleChildren(styled, nil)
+}
+
+// styleChildren styles children of the styled node and its ::before and ::after.
+// The children may share styles of those in cache, and then the cache is returned.
+func (s *styler) styleChildren(styled *styledNode, cache sharingCache) sharingCache {
+	node, computed := styled.node, styled.computedValues
+	before := s.stylePseudoElement(node, "before", computed)
+	after := s.stylePseudoElement(node, "after", computed)
+	if before != nil {
+		styled.children = append(styled.children, before)
+	}
+	if node.NodeType == ElementNode {
+		s.ancestors.push(node)
+	}
+	cache = append(sharingCache{}, cache...)
+	start := len(styled.children)
+	styled.children = append(styled.children, make([]*styledNode, len(node.Children))...)
+	children := styled.children[start:]
+	var wg sync.WaitGroup
+	for i, child := range node.Children {
+		if !s.spawn(child, computed, &children[i], &wg) {
+			children[i] = s.styleSibling(child, computed, &cache)
+		}
+	}
+	wg.Wait()
+	if node.NodeType == ElementNode {
+		s.ancestors.pop(node)
+	}
+	if after != nil {
+		styled.children = append(styled.children, after)
+	}
+	return cache
+}
+
+// styleSibling styles the node, sharing style of a recent sibling if it can
+func (s *styler) styleSibling(node *Node, parent propertyMap, cache *sharingCache) *styledNode {
+	key, ok := sharingKey(node)
+	if !s.shareStyles || !ok {
+		return s.styleTree(node, parent)
+	}
+	if shared := s.lookupShared(*cache, node, key); shared != nil {
+		styled := &styledNode{
+			node:            node,
+			specifiedValues: shared.styled.specifiedValues,
+			computedValues:  shared.styled.computedValues,
+			children:        []*styledNode{},
+			firstLine:       shared.styled.firstLine,
+			firstLetter:     shared.styled.firstLetter,
+		}
+		s.updateContainer(styled)
+		// children of siblings with the same style can share styles too
+		s.styleChildren(styled, shared.children)
+		return styled
+	}
+	styled, children := s.styleNode(node, parent)
+	cache.add(sharedStyle{key, nil, styled, children})
+	return styled
+}
+
+// matchPseudoRules returns computed values of properties declared for pseudo-element,
+// nil if there are none
+func (s *styler) matchPseudoRules(node *Node, pseudo string, parent propertyMap) propertyMap {
+	if !s.pseudoElements[pseudo] {
+		return nil
+	}
+	values := s.matchRules(node, pseudo)
+	if len(values) == 0 {
+		return nil
+	}
+	computed := computeValues(values, parent, s.rootFontSize)
+	for name := range computed {
+		if _, ok := values[name]; !ok {
+			delete(computed, name)
+		}
+	}
+	return computed
+}
+
+// stylePseudoElement returns styled node for ::before or ::after of the element,
+// or nil if it has no content
+func (s *styler) stylePseudoElement(node *Node, pseudo string, parent propertyMap) *styledNode {
+	if node.NodeType != ElementNode || !s.pseudoElements[pseudo] {
+		return nil
+	}
+	values := s.matchRules(node, pseudo)
+	if len(values) == 0 {
+		return nil
+	}
+	computed := computeValues(values, parent, s.rootFontSize)
+	if content := computed["content"]; content.isKeyword("none") || content.isKeyword("normal") {
+		return nil
+	}
+	return &styledNode{
+		node:            newPseudoElementNode(node, pseudo),
+		specifiedValues: values,
+		computedValues:  computed,
+		children:        []*styledNode{},
+		pseudo:          pseudo,
 	}
 }
 
 func (n *styledNode) lookup(k string) (Value, bool) {
-	v, ok := n.specifiedValues[k]
+	v, ok := n.computedValues[k]
 	return v, ok
 }
 
 func (n *styledNode) lookupOr(k string, elseVal Value) Value {
-	v, ok := n.specifiedValues[k]
+	v, ok := n.computedValues[k]
 	if ok {
 		return v
 	}
 	return elseVal
 }
-
-func (n *styledNode) lookupDouble(k1, k2 string, elseVal Value) Value {
-	v, ok := n.lookup(k1)
-	if ok {
-		return v
-	}
-	return n.lookupOr(k2, elseVal)
-}
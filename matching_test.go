@@ -74,6 +74,334 @@ func Test_styleTree(t *testing.T) {
 	})
 }
 
+func Test_cascade(t *testing.T) {
+	styleOf := func(html string, sheets ...*Stylesheet) propertyMap {
+		node, _ := parseHTMLWrapped(strings.NewReader(html))
+		return styleTree(node, sheets...).children[0].specifiedValues
+	}
+	sheet := func(css string, origin Origin) *Stylesheet {
+		s, err := ParseStylesheetOrigin(strings.NewReader(css), origin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	t.Run("author wins over user and user agent", func(t *testing.T) {
+		pm := styleOf(`<p>x</p>`,
+			sheet(`p {color: #000001}`, AuthorOrigin),
+			sheet(`p {color: #000002}`, UserOrigin),
+			sheet(`p {color: #000003}`, UserAgentOrigin))
+		if pm["color"].color.B != 1 {
+			t.Error(pm["color"])
+		}
+	})
+	t.Run("important wins over specificity", func(t *testing.T) {
+		pm := styleOf(`<p id="a">x</p>`, sheet(`p {color: #000001 !important} #a {color: #000002}`, AuthorOrigin))
+		if pm["color"].color.B != 1 {
+			t.Error(pm["color"])
+		}
+	})
+	t.Run("important user wins over important author", func(t *testing.T) {
+		pm := styleOf(`<p id="a">x</p>`,
+			sheet(`#a {color: #000001 !important}`, AuthorOrigin),
+			sheet(`p {color: #000002 ! IMPORTANT}`, UserOrigin))
+		if pm["color"].color.B != 2 {
+			t.Error(pm["color"])
+		}
+	})
+	t.Run("important user agent wins over everything", func(t *testing.T) {
+		pm := styleOf(`<p id="a">x</p>`,
+			sheet(`#a {display: block !important}`, AuthorOrigin),
+			sheet(`p {display: inline !important}`, UserOrigin),
+			sheet(`p {display: none !important}`, UserAgentOrigin))
+		if pm["display"].keyword != "none" {
+			t.Error(pm["display"])
+		}
+	})
+	t.Run("specificity of matched selector counts, not of the group", func(t *testing.T) {
+		pm := styleOf(`<h1 class="note">x</h1>`, sheet(`.note {color: #000001} h1, h2, h3 {color: #000002}`, AuthorOrigin))
+		if pm["color"].color.B != 1 {
+			t.Error(pm["color"])
+		}
+	})
+	t.Run("later sheet wins on equal specificity", func(t *testing.T) {
+		for i := 0; i < 20; i++ {
+			pm := styleOf(`<p class="a">x</p>`,
+				sheet(`.a {color: #000001} p.a {color: #000002}`, AuthorOrigin),
+				sheet(`p.a {color: #000003} p.a {color: #000004} .a {color: #000005}`, AuthorOrigin))
+			if pm["color"].color.B != 4 {
+				t.Fatal(pm["color"])
+			}
+		}
+	})
+	t.Run("inline style wins over selectors", func(t *testing.T) {
+		pm := styleOf(`<p id="a" style="color: #000001; margin: 4px">x</p>`, sheet(`#a {color: #000002; margin: 1px}`, AuthorOrigin))
+		if pm["color"].color.B != 1 || pm["margin"].length != 4 {
+			t.Error(pm)
+		}
+	})
+	t.Run("important in stylesheet wins over inline style", func(t *testing.T) {
+		pm := styleOf(`<p style="color: #000001">x</p>`, sheet(`p {color: #000002 !important}`, AuthorOrigin))
+		if pm["color"].color.B != 2 {
+			t.Error(pm["color"])
+		}
+	})
+	t.Run("important inline style wins over important in stylesheet", func(t *testing.T) {
+		pm := styleOf(`<p id="a" style="color: #000001 !important">x</p>`, sheet(`#a {color: #000002 !important}`, AuthorOrigin))
+		if pm["color"].color.B != 1 {
+			t.Error(pm["color"])
+		}
+	})
+	t.Run("inline style loses to important user style", func(t *testing.T) {
+		pm := styleOf(`<p style="color: #000001 !important">x</p>`, sheet(`p {color: #000002 !important}`, UserOrigin))
+		if pm["color"].color.B != 2 {
+			t.Error(pm["color"])
+		}
+	})
+	t.Run("later rule wins", func(t *testing.T) {
+		pm := styleOf(`<p>x</p>`, sheet(`p {color: #000001} p {color: #000002}`, AuthorOrigin))
+		if pm["color"].color.B != 2 {
+			t.Error(pm["color"])
+		}
+	})
+}
+
+func Test_matches(t *testing.T) {
+	html := `<nav class="top"><ul><li id="one"><a class="x">1</a></li><li id="two"><a>2</a></li><li id="three">3</li></ul></nav>`
+	root, _ := parseHTMLWrapped(strings.NewReader(html))
+	byID := func(id string) *Node {
+		var found *Node
+		var walk func(n *Node)
+		walk = func(n *Node) {
+			if i := n.GetID(); i != nil && *i == id {
+				found = n
+			}
+			for _, c := range n.Children {
+				walk(c)
+			}
+		}
+		walk(root)
+		return found
+	}
+	a := byID("one").Children[0]
+	tests := []struct {
+		node     *Node
+		selector string
+		want     bool
+	}{
+		{a, "nav a", true},
+		{a, "nav > a", false},
+		{a, "li > a", true},
+		{a, ".top li a.x", true},
+		{a, "ul > a", false},
+		{byID("two"), "#one + li", true},
+		{byID("three"), "#one + li", false},
+		{byID("three"), "#one ~ li", true},
+		{byID("one"), "#two ~ li", false},
+		{byID("two"), "nav li#two", true},
+		{a, "*", true},
+		{root, "*", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			sel, err := parseSelector(mr(tt.selector))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := matches(tt.node, sel); got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	t.Run("attributes", func(t *testing.T) {
+		n := NewElementNode("a", map[string]string{
+			"href": "https://example.com/doc.pdf", "data-rel": "next Prev", "lang": "en-US", "type": "TEXT", "data-x": ""}, nil)
+		tests := []struct {
+			selector string
+			want     bool
+		}{
+			{"[href]", true},
+			{"[title]", false},
+			{"[data-x]", true},
+			{"[data-x=\"\"]", true},
+			{"[href^=https]", true},
+			{"[href$='.pdf']", true},
+			{"[href*=example]", true},
+			{"[href*=\"\"]", false},
+			{"[data-rel~=next]", true},
+			{"[data-rel~=prev]", false},
+			{"[data-rel~=prev i]", true},
+			{"[lang|=en]", true},
+			{"[lang|=e]", false},
+			{"[type=text]", true},
+			{"[type=Text]", true},
+			{"[type=text s]", false},
+			{"[href='HTTPS://EXAMPLE.COM/DOC.PDF' i]", true},
+		}
+		for _, tt := range tests {
+			sel, err := parseSelector(mr(tt.selector))
+			if err != nil {
+				t.Fatal(tt.selector, err)
+			}
+			if got := matches(n, sel); got != tt.want {
+				t.Errorf("%s: matches() = %v, want %v", tt.selector, got, tt.want)
+			}
+		}
+	})
+	t.Run("structural pseudo-classes", func(t *testing.T) {
+		html := `<table><tr id="r1" class="x"><td>1</td></tr><tr id="r2"><th></th></tr><tr id="r3" class="x"></tr><tr id="r4" class="x"></tr><tr id="r5">5</tr></table>`
+		root, _ := parseHTMLWrapped(strings.NewReader(html))
+		table := root.Children[0]
+		rows := table.Children
+		tests := []struct {
+			node     *Node
+			selector string
+			want     bool
+		}{
+			{table, ":root", true},
+			{rows[0], ":root", false},
+			{rows[2], ":empty", true},
+			{rows[4], ":empty", false},
+			{rows[1].Children[0], "th:empty:only-child", true},
+			{rows[0], "tr:first-child", true},
+			{rows[1], "tr:first-child", false},
+			{rows[4], "tr:last-child", true},
+			{rows[0], ":only-child", false},
+			{table, ":only-child", true},
+			{rows[0], "tr:nth-child(odd)", true},
+			{rows[1], "tr:nth-child(odd)", false},
+			{rows[1], "tr:nth-child(even)", true},
+			{rows[2], "tr:nth-child(-n+3)", true},
+			{rows[3], "tr:nth-child(-n+3)", false},
+			{rows[3], "tr:nth-last-child(2)", true},
+			{rows[2], "tr:nth-child(2 of .x)", true},
+			{rows[3], "tr:nth-child(2 of .x)", false},
+			{rows[1], "tr:nth-child(2 of .x)", false},
+			{rows[3], "tr:nth-last-child(1 of .x)", true},
+			{rows[0], "tr:first-of-type", true},
+			{rows[4], "tr:last-of-type", true},
+			{rows[1].Children[0], "th:only-of-type", true},
+			{rows[2], "tr:nth-of-type(3)", true},
+			{rows[2], "tr:nth-last-of-type(3)", true},
+			{rows[0], "tr:not(.x)", false},
+			{rows[1], "tr:not(.x, #r3)", true},
+			{rows[1], ":is(#r1, #r2)", true},
+			{rows[2], ":where(#r1, #r2)", false},
+			{rows[0].Children[0], "table :is(tr.x) > td", true},
+		}
+		for _, tt := range tests {
+			sel, err := parseSelector(mr(tt.selector))
+			if err != nil {
+				t.Fatal(tt.selector, err)
+			}
+			if got := matches(tt.node, sel); got != tt.want {
+				t.Errorf("%s: matches() = %v, want %v", tt.selector, got, tt.want)
+			}
+		}
+	})
+	t.Run(":has", func(t *testing.T) {
+		html := `<div id="c1" class="card"><p><img></img></p></div><div id="c2" class="card"><img></img></div><h2 id="h"></h2><p id="p" class="x"></p><div id="c3" class="card"><p class="x"><b>b</b></p></div>`
+		root, _ := parseHTMLWrapped(strings.NewReader(html))
+		c1, c2, h, c3 := root.Children[0], root.Children[1], root.Children[2], root.Children[4]
+		tests := []struct {
+			node     *Node
+			selector string
+			want     bool
+		}{
+			{c1, ".card:has(img)", true},
+			{c1, ".card:has(> img)", false},
+			{c2, ".card:has(> img)", true},
+			{c1, ":has(p img)", true},
+			{c1, ":has(> p > img)", true},
+			{c2, ":has(p img)", false},
+			{h, "h2:has(+ .x)", true},
+			{h, "h2:has(+ div)", false},
+			{h, "h2:has(~ div)", true},
+			{h, "h2:has(~ div .x b)", true},
+			{h, "h2:has(~ div > b)", false},
+			{h, "h2:has(+ .x + div)", true},
+			{h, "h2:has(+ div + div)", false},
+			{h, "h2:has(+ .x ~ .card)", true},
+			{c3, ":has(.x > b, span)", true},
+			{c3, ":has(span)", false},
+			{c3.Children[0].Children[0], ".card:has(.x) b", true},
+			{c3.Children[0].Children[0], ".card:not(:has(.x)) b", false},
+		}
+		for _, tt := range tests {
+			sel, err := parseSelector(mr(tt.selector))
+			if err != nil {
+				t.Fatal(tt.selector, err)
+			}
+			if got := newMatchContext().matches(tt.node, sel); got != tt.want {
+				t.Errorf("%s: matches() = %v, want %v", tt.selector, got, tt.want)
+			}
+			if got := matches(tt.node, sel); got != tt.want {
+				t.Errorf("%s: without cache matches() = %v, want %v", tt.selector, got, tt.want)
+			}
+		}
+	})
+	t.Run("multiple classes", func(t *testing.T) {
+		n := NewElementNode("p", map[string]string{"class": "a  b"}, nil)
+		sel, _ := parseSelector(mr(".b.a"))
+		if !matches(n, sel) {
+			t.Error("should match")
+		}
+		sel, _ = parseSelector(mr(".a.c"))
+		if matches(n, sel) {
+			t.Error("should not match")
+		}
+	})
+}
+
+func Test_visitRelativeCandidates(t *testing.T) {
+	html := `<ul><li id="a"><b></b></li><li id="b"></li><li id="c"></li><li id="d"></li></ul>`
+	root, _ := parseHTMLWrapped(strings.NewReader(html))
+	ul := root.Children[0]
+	tests := []struct {
+		anchor   *Node
+		selector string
+		want     string
+	}{
+		{ul, "> li", "a b c d"},
+		{ul, "> li > b", "a b b c d"},
+		{ul, "li", "a b b c d"},
+		{ul.Children[0], "+ li", "b"},
+		{ul.Children[0], "+ li + li", "c"},
+		{ul.Children[0], "+ li ~ li", "b c d"},
+		{ul.Children[0], "~ li", "b c d"},
+		{ul.Children[2], "+ li + li", ""},
+	}
+	for _, tt := range tests {
+		sel, err := parseSelector(mr(":has(" + tt.selector + ")"))
+		if err != nil {
+			t.Fatal(tt.selector, err)
+		}
+		visited := []string{}
+		visitRelativeCandidates(tt.anchor, sel.pseudo[0].selectors[0], func(n *Node) bool {
+			id := n.Attributes["id"]
+			if id == "" {
+				id = n.TagName()
+			}
+			visited = append(visited, id)
+			return false
+		})
+		if got := strings.Join(visited, " "); got != tt.want {
+			t.Errorf("%s: visited %q, want %q", tt.selector, got, tt.want)
+		}
+	}
+	t.Run("stops at match", func(t *testing.T) {
+		sel, _ := parseSelector(mr(":has(~ li)"))
+		count := 0
+		found := visitRelativeCandidates(ul.Children[0], sel.pseudo[0].selectors[0], func(n *Node) bool {
+			count++
+			return true
+		})
+		if !found || count != 1 {
+			t.Errorf("found = %v after %d candidates, want true after 1", found, count)
+		}
+	})
+}
+
 func pmapContainsKey(decls propertyMap, k string) bool {
 	_, ok := decls[k]
 	return ok
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Media queries, https://www.w3.org/TR/mediaqueries-4/
+
+// RenderOptions describe the output device for @media rules, and how documents are styled,
+// zero fields take values of DefaultRenderOptions
+type RenderOptions struct {
+	// MediaType is "screen" or "print"
+	MediaType string
+	// Resolution is count of device pixels per CSS pixel
+	Resolution float32
+	// ColorScheme is "light" or "dark"
+	ColorScheme string
+	// Workers is the number of goroutines styling a document, it is styled sequentially
+	// by one. Subtrees of at least ParallelThreshold nodes are styled concurrently.
+	Workers           int
+	ParallelThreshold int
+}
+
+// DefaultRenderOptions are options of a usual screen, documents are styled sequentially
+var DefaultRenderOptions = RenderOptions{MediaType: "screen", Resolution: 1, ColorScheme: "light", Workers: 1, ParallelThreshold: 100}
+
+// defaultViewport is the viewport for styling before the document is laid out
+var defaultViewport = bounds{800, 600}
+
+// mediaEnvironment is what media queries are evaluated against
+type mediaEnvironment struct {
+	RenderOptions
+	width, height float32
+	features      featureValues
+	// containers are query containers found by styling, @container rules
+	// are evaluated against them, nil if no sheet has @container rules
+	containers map[*Node]*queryContainer
+}
+
+// featureValues are values of features in an environment:
+// ranges are numbers, with lengths in px, discrete are keywords
+type featureValues struct {
+	ranges   map[string]float32
+	discrete map[string]string
+}
+
+func newMediaEnvironment(width, height int, options RenderOptions) *mediaEnvironment {
+	if options.MediaType == "" {
+		options.MediaType = DefaultRenderOptions.MediaType
+	}
+	if options.Resolution == 0 {
+		options.Resolution = DefaultRenderOptions.Resolution
+	}
+	if options.ColorScheme == "" {
+		options.ColorScheme = DefaultRenderOptions.ColorScheme
+	}
+	if options.Workers == 0 {
+		options.Workers = DefaultRenderOptions.Workers
+	}
+	if options.ParallelThreshold == 0 {
+		options.ParallelThreshold = DefaultRenderOptions.ParallelThreshold
+	}
+	env := &mediaEnvironment{RenderOptions: options, width: float32(width), height: float32(height)}
+	env.features = featureValues{
+		ranges:   map[string]float32{"width": env.width, "height": env.height, "resolution": options.Resolution},
+		discrete: map[string]string{"orientation": orientation(env.width, env.height), "prefers-color-scheme": options.ColorScheme},
+	}
+	return env
+}
+
+// orientation is portrait unless width is greater than height
+func orientation(width, height float32) string {
+	if width > height {
+		return "landscape"
+	}
+	return "portrait"
+}
+
+func defaultMediaEnvironment() *mediaEnvironment {
+	return newMediaEnvironment(defaultViewport.width, defaultViewport.height, DefaultRenderOptions)
+}
+
+// mediaQueryList matches if some of its queries match, empty list matches all media
+type mediaQueryList []mediaQuery
+
+// mediaQuery is like "only screen and (min-width: 600px)"
+type mediaQuery struct {
+	not  bool
+	only bool
+	// mediaType is empty if the query has only features
+	mediaType string
+	features  []mediaFeature
+}
+
+// mediaFeature is like (min-width: 600px) with op ":", or range like (width >= 600px),
+// or (400px < width <= 600px) with the lower bound in low. op is empty in boolean context like (color).
+type mediaFeature struct {
+	name  string
+	op    string
+	value string
+	// lowOp is the comparison of low and the feature, < or <=, or > and >= for upper bound
+	low   string
+	lowOp string
+}
+
+// matchesMedia tells if all @media rules around the rule match
+func (r *Rule) matchesMedia(env *mediaEnvironment) bool {
+	for _, list := range r.media {
+		if !list.matches(env) {
+			return false
+		}
+	}
+	return true
+}
+
+func (list mediaQueryList) matches(env *mediaEnvironment) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, q := range list {
+		if q.matches(env) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches evaluates the query, features unknown to the environment make it unknown,
+// unless it is false anyway, and unknown queries don't match even with not
+func (q mediaQuery) matches(env *mediaEnvironment) bool {
+	result := q.mediaType == "" || q.mediaType == "all" || q.mediaType == env.MediaType
+	unknown := false
+	for _, f := range q.features {
+		matches, known := f.evaluate(env.features)
+		if known {
+			result = result && matches
+		} else {
+			unknown = true
+		}
+	}
+	if unknown && result {
+		return false
+	}
+	return result != q.not
+}
+
+// evaluate evaluates the feature, known is false for features out of values,
+// which never match. Invalid values never match too.
+func (f mediaFeature) evaluate(values featureValues) (matches, known bool) {
+	name, op := f.name, f.op
+	if op == ":" {
+		op = "="
+		if strings.HasPrefix(name, "min-") {
+			name, op = name[4:], ">="
+		} else if strings.HasPrefix(name, "max-") {
+			name, op = name[4:], "<="
+		}
+	}
+	if actual, ok := values.ranges[name]; ok {
+		if f.op == "" {
+			return actual != 0, true
+		}
+		expected, ok := parseRangeValue(name, f.value)
+		if !ok || !compareRange(actual, op, expected) {
+			return false, true
+		}
+		if f.low != "" {
+			low, ok := parseRangeValue(name, f.low)
+			return ok && compareRange(low, f.lowOp, actual), true
+		}
+		return true, true
+	}
+	if actual, ok := values.discrete[name]; ok {
+		return name == f.name && (f.op == "" || f.op == ":" && f.value == actual), true
+	}
+	return false, false
+}
+
+// parseRangeValue parses value of the range feature, resolution or length
+func parseRangeValue(name, value string) (float32, bool) {
+	if name == "resolution" {
+		return parseResolution(value)
+	}
+	return parseMediaLength(value)
+}
+
+func compareRange(a float32, op string, b float32) bool {
+	switch op {
+	case "=":
+		return a == b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	}
+	return false
+}
+
+// parseMediaLength parses length of media feature in px, em and rem are of the initial font size
+func parseMediaLength(s string) (float32, bool) {
+	v, err := parseValue(bufio.NewReader(strings.NewReader(s)))
+	if err == nil && v.valueType == Number && v.length == 0 {
+		return 0, true
+	}
+	if err != nil || v.valueType != Length || v.unitType == Percent {
+		return 0, false
+	}
+	switch v.unitType {
+	case Em, Rem:
+		return v.length * defaultFontSize, true
+	case Ex, Ch:
+		return v.length * defaultFontSize / 2, true
+	case Vw, Vh, Vmin, Vmax:
+		return 0, false
+	}
+	return v.toPx(), true
+}
+
+// resolutionUnits are dots per px of each unit
+var resolutionUnits = map[string]float32{"dppx": 1, "x": 1, "dpi": 1.0 / 96, "dpcm": 2.54 / 96}
+
+func parseResolution(s string) (float32, bool) {
+	for unit, ratio := range resolutionUnits {
+		if strings.HasSuffix(s, unit) {
+			n, err := strconv.ParseFloat(strings.TrimSuffix(s, unit), 32)
+			if err == nil {
+				return float32(n) * ratio, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// notAll replaces malformed media queries, https://www.w3.org/TR/mediaqueries-4/#error-handling
+var notAll = mediaQuery{not: true, mediaType: "all"}
+
+// parseMediaQueryList parses queries separated by commas up to { of @media or ; of @import.
+// Malformed queries, empty ones after commas included, are not all and don't affect others.
+func parseMediaQueryList(r *bufio.Reader) mediaQueryList {
+	list := mediaQueryList{}
+	for {
+		skipSpaces(r)
+		if isMediaQueryListEnd(r) {
+			if len(list) > 0 {
+				list = append(list, notAll)
+			}
+			return list
+		}
+		q, err := parseMediaQuery(r)
+		skipSpaces(r)
+		if err != nil || !isMediaQueryListEnd(r) && !isNextChar(r, ',') {
+			q = notAll
+			skipMediaQuery(r)
+		}
+		list = append(list, q)
+		if isMediaQueryListEnd(r) {
+			return list
+		}
+		r.ReadRune()
+	}
+}
+
+func isMediaQueryListEnd(r *bufio.Reader) bool {
+	return isNextChar(r, '{') || isNextChar(r, ';') || isNextCharEOF(r)
+}
+
+// skipMediaQuery skips the rest of malformed query up to the comma out of parentheses,
+// or the end of the list
+func skipMediaQuery(r *bufio.Reader) {
+	depth := 0
+	for !isMediaQueryListEnd(r) {
+		if isNextChar(r, ',') && depth == 0 {
+			return
+		}
+		switch getChar(r) {
+		case '(':
+			depth++
+		case ')':
+			if depth > 0 {
+				depth--
+			}
+		}
+	}
+}
+
+// parseMediaQuery parses [not | only] type [and (feature)]* or (feature) [and (feature)]*
+func parseMediaQuery(r *bufio.Reader) (mediaQuery, error) {
+	q := mediaQuery{}
+	if !isNextChar(r, '(') {
+		word := strings.ToLower(readIdent(r))
+		switch word {
+		case "not", "only":
+			q.not, q.only = word == "not", word == "only"
+			skipSpaces(r)
+			if !isNextChar(r, '(') {
+				q.mediaType = strings.ToLower(readIdent(r))
+			}
+		default:
+			q.mediaType = word
+		}
+		if q.mediaType == "" && !isNextChar(r, '(') {
+			return q, fmt.Errorf("media type is expected")
+		}
+	}
+	for {
+		skipSpaces(r)
+		if q.mediaType != "" || len(q.features) > 0 {
+			if !isNextCharMatches(r, nameStart) {
+				return q, nil
+			}
+			if and := strings.ToLower(readIdent(r)); and != "and" {
+				return q, fmt.Errorf("and is expected instead of %q", and)
+			}
+			skipSpaces(r)
+		}
+		f, err := parseMediaFeature(r)
+		if err != nil {
+			return q, err
+		}
+		q.features = append(q.features, f)
+	}
+}
+
+var (
+	// featureName accepts vendor prefixed names too, they are unknown features
+	featureName        = regexp.MustCompile(`^-*[a-z][a-z0-9-]*$`)
+	featureRange       = regexp.MustCompile(`^([a-z][a-z0-9-]*)\s*(<=|>=|<|>|=)\s*(\S.*)$`)
+	featureRangeFlip   = regexp.MustCompile(`^(.*?\S)\s*(<=|>=|<|>|=)\s*([a-z][a-z0-9-]*)$`)
+	featureRangeBounds = regexp.MustCompile(`^(.*?\S)\s*(<=|<|>=|>)\s*([a-z][a-z0-9-]*)\s*(<=|<|>=|>)\s*(\S.*)$`)
+)
+
+// flippedComparisons turn "value op name" into "name op value"
+var flippedComparisons = map[string]string{"<": ">", "<=": ">=", ">": "<", ">=": "<=", "=": "="}
+
+// parseMediaFeature parses (name), (name: value) or range forms
+// (name op value), (value op name) and (value op name op value)
+func parseMediaFeature(r *bufio.Reader) (mediaFeature, error) {
+	f := mediaFeature{}
+	if !isNextChar(r, '(') {
+		return f, fmt.Errorf("( is expected before media feature")
+	}
+	r.ReadRune()
+	text := new(strings.Builder)
+	depth := 0
+	for depth > 0 || !isNextChar(r, ')') {
+		if isMediaQueryListEnd(r) {
+			return f, fmt.Errorf(") is expected after media feature")
+		}
+		c := getChar(r)
+		if c == '(' {
+			depth++
+		} else if c == ')' {
+			depth--
+		}
+		text.WriteRune(c)
+	}
+	r.ReadRune()
+	s := strings.ToLower(strings.TrimSpace(text.String()))
+	if name, value, ok := strings.Cut(s, ":"); ok {
+		f.name, f.op, f.value = strings.TrimSpace(name), ":", strings.TrimSpace(value)
+	} else if m := featureRangeBounds.FindStringSubmatch(s); m != nil {
+		if m[2][0] != m[4][0] {
+			return f, fmt.Errorf("bounds of %s must be in the same direction", m[3])
+		}
+		f.low, f.lowOp, f.name, f.op, f.value = m[1], m[2], m[3], m[4], m[5]
+	} else if m := featureRange.FindStringSubmatch(s); m != nil {
+		f.name, f.op, f.value = m[1], m[2], m[3]
+	} else if m := featureRangeFlip.FindStringSubmatch(s); m != nil {
+		f.name, f.op, f.value = m[3], flippedComparisons[m[2]], m[1]
+	} else {
+		f.name = s
+	}
+	if !featureName.MatchString(f.name) {
+		return f, fmt.Errorf("name of media feature is expected instead of %q", f.name)
+	}
+	return f, nil
+}
+
+// String serializes the query list like "screen and (min-width: 600px), print"
+func (list mediaQueryList) String() string {
+	return list.format(false)
+}
+
+func (list mediaQueryList) format(minify bool) string {
+	queries := []string{}
+	for _, q := range list {
+		parts := []string{}
+		if q.not {
+			parts = append(parts, "not")
+		} else if q.only {
+			parts = append(parts, "only")
+		}
+		if q.mediaType != "" {
+			parts = append(parts, q.mediaType)
+		}
+		for i, f := range q.features {
+			if i > 0 || q.mediaType != "" {
+				parts = append(parts, "and")
+			}
+			parts = append(parts, f.format(minify))
+		}
+		queries = append(queries, strings.Join(parts, " "))
+	}
+	return strings.Join(queries, separator(",", minify))
+}
+
+// format serializes the feature in parentheses
+func (f mediaFeature) format(minify bool) string {
+	comparison := func(op string) string {
+		if minify {
+			return op
+		}
+		return " " + op + " "
+	}
+	switch {
+	case f.op == "":
+		return "(" + f.name + ")"
+	case f.op == ":":
+		return "(" + f.name + separator(":", minify) + f.value + ")"
+	case f.low != "":
+		return "(" + f.low + comparison(f.lowOp) + f.name + comparison(f.op) + f.value + ")"
+	}
+	return "(" + f.name + comparison(f.op) + f.value + ")"
+}
+
+// mediaMatchChanged returns rules, which match in one environment but not in the other
+func mediaMatchChanged(sheets []*Stylesheet, old, new *mediaEnvironment) []*Rule {
+	changed := []*Rule{}
+	for _, sheet := range sheets {
+		for _, rule := range sheet.rules {
+			if len(rule.media) > 0 && rule.matchesMedia(old) != rule.matchesMedia(new) {
+				changed = append(changed, rule)
+			}
+		}
+	}
+	return changed
+}
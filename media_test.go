@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func Test_mediaQueryList(t *testing.T) {
+	screen := newMediaEnvironment(800, 600, RenderOptions{})
+	tests := []struct {
+		query string
+		env   *mediaEnvironment
+		want  bool
+	}{
+		{"", screen, true},
+		{"screen", screen, true},
+		{"print", screen, false},
+		{"all", newMediaEnvironment(800, 600, RenderOptions{MediaType: "print"}), true},
+		{"not print", screen, true},
+		{"only screen and (min-width: 600px)", screen, true},
+		{"screen and (max-width: 799px)", screen, false},
+		{"(width: 800px) and (height: 600px)", screen, true},
+		{"(min-width: 50em)", screen, true},
+		{"(min-width: 51em)", screen, false},
+		{"(min-width: 0)", screen, true},
+		{"print, (orientation: landscape)", screen, true},
+		{"(orientation: portrait)", screen, false},
+		{"(orientation: portrait)", newMediaEnvironment(300, 600, RenderOptions{}), true},
+		{"not (min-width: 1000px)", screen, true},
+		{"(min-resolution: 2dppx)", screen, false},
+		{"(min-resolution: 192dpi)", newMediaEnvironment(800, 600, RenderOptions{Resolution: 2}), true},
+		{"(resolution: 1x)", screen, true},
+		{"(prefers-color-scheme: dark)", screen, false},
+		{"(prefers-color-scheme: dark)", newMediaEnvironment(800, 600, RenderOptions{ColorScheme: "dark"}), true},
+		{"(width >= 600px)", screen, true},
+		{"(width < 800px)", screen, false},
+		{"(400px < width <= 800px)", screen, true},
+		{"(800px > height > 600px)", screen, false},
+		{"(min-width)", screen, false},
+		{"(hover: hover)", screen, false},
+		{"not (hover: hover)", screen, false},
+		{"not print and (hover: hover)", screen, true},
+		{"screen and (-webkit-min-device-pixel-ratio: 2)", screen, false},
+		{"not screen and (-webkit-min-device-pixel-ratio: 2)", screen, false},
+		{"(min-width: calc(10px + 2em))", screen, false},
+		{"tv", screen, false},
+	}
+	for _, tt := range tests {
+		list := parseMediaQueryList(bufio.NewReader(strings.NewReader(tt.query + " {")))
+		if got := list.matches(tt.env); got != tt.want {
+			t.Errorf("%q: got %v, want %v", tt.query, got, tt.want)
+		}
+		if got := list.String(); got != tt.query {
+			t.Errorf("%q is serialized as %q", tt.query, got)
+		}
+	}
+	malformed := []struct {
+		query, want string
+	}{
+		{"screen and", "not all"},
+		{"(min-width: 1px", "not all"},
+		{"screen or print", "not all"},
+		{"screen,", "screen, not all"},
+		{"(1px < width > 2px)", "not all"},
+		{"(orientation landscape), screen", "not all, screen"},
+		{"print and (color) (x), (width: 800px)", "not all, (width: 800px)"},
+		{"((min-width: 1px) and (max-width: 2px)), print", "not all, print"},
+	}
+	for _, tt := range malformed {
+		list := parseMediaQueryList(bufio.NewReader(strings.NewReader(tt.query + " {")))
+		if got := list.String(); got != tt.want {
+			t.Errorf("%q is parsed as %q, want %q", tt.query, got, tt.want)
+		}
+	}
+	if (mediaQueryList{notAll}).matches(screen) {
+		t.Error("not all matches")
+	}
+}
+
+func TestMediaRules(t *testing.T) {
+	css := `@charset "utf-8";
+		p {color: #000001}
+		@media screen and (max-width: 600px) {
+			p {color: #000002}
+			@media (prefers-color-scheme: dark) {
+				p {color: #000003}
+			}
+		}
+		@keyframes spin { from {width: 0} to {width: 10px} }
+		@media print { p {color: #000004} }
+		p {margin-top: 1px}`
+	sheet := mustParseStylesheet(t, css)
+	if len(sheet.rules) != 5 || sheet.rules[2].line != 6 {
+		t.Fatalf("got rules %s", sheet)
+	}
+	tests := []struct {
+		width   int
+		options RenderOptions
+		want    uint8
+	}{
+		{800, DefaultRenderOptions, 1},
+		{600, DefaultRenderOptions, 2},
+		{600, RenderOptions{ColorScheme: "dark"}, 3},
+		{800, RenderOptions{ColorScheme: "dark"}, 1},
+		{600, RenderOptions{MediaType: "print"}, 4},
+	}
+	for _, tt := range tests {
+		node, err := parseHTMLWrapped(strings.NewReader(`<p>1</p>`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := styleTreeMedia(node, newMediaEnvironment(tt.width, 400, tt.options), sheet).children[0]
+		if got := p.computedValues["color"].color.B; got != tt.want || p.computedValues["margin-top"].length != 1 {
+			t.Errorf("width %d, %v: got color %d", tt.width, tt.options, got)
+		}
+	}
+	if _, err := ParseStylesheet(strings.NewReader("@media screen { p {}")); err == nil {
+		t.Error("unclosed @media is accepted")
+	}
+}
+
+func TestMalformedMediaRules(t *testing.T) {
+	sheet := mustParseStylesheet(t, `p {color: #000001}
+		@media screen and (-webkit-min-device-pixel-ratio: 2) { p {color: #000002} }
+		@media (orientation landscape) { p {color: #000003} }
+		@media (min-width: calc(10px + 2em)) { p {color: #000004} }
+		@media not (hover: hover) { p {color: #000005} }
+		@media screen;
+		@container (min-width) and { p {color: #000006} }
+		@media (orientation landscape), screen { p {margin-top: 1px} }`)
+	if len(sheet.rules) != 6 {
+		t.Fatalf("got rules %s", sheet)
+	}
+	node, err := parseHTMLWrapped(strings.NewReader(`<p>1</p>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := styleTree(node, sheet).children[0]
+	if got := p.computedValues["color"].color.B; got != 1 || p.computedValues["margin-top"].length != 1 {
+		t.Errorf("got color %d, margin-top %v", got, p.computedValues["margin-top"])
+	}
+}
+
+func TestStyledDocumentMedia(t *testing.T) {
+	node, err := parseHTMLWrapped(strings.NewReader(`<div><p>1</p><span>2</span></div>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sheet := mustParseStylesheet(t, `p {color: #000001} @media (max-width: 500px) { p {color: #000002} }
+		@media (prefers-color-scheme: dark) { span {color: #000003} }`)
+	doc := NewStyledDocument(node, sheet)
+	p, span := node.Children[0].Children[0], node.Children[0].Children[1]
+	spanStyle := doc.styled[span]
+	doc.Layout(400, 300)
+	if got := doc.ComputedStyle(p)["color"]; got != "rgb(0, 0, 2)" {
+		t.Errorf("got %s for narrow viewport", got)
+	}
+	if doc.styled[span] != spanStyle || doc.styled[span].restyle != 0 {
+		t.Error("span is restyled")
+	}
+	doc.SetRenderOptions(RenderOptions{ColorScheme: "dark"})
+	if got := doc.ComputedStyle(span)["color"]; got != "rgb(0, 0, 3)" {
+		t.Errorf("got %s for dark scheme", got)
+	}
+	doc.Layout(800, 300)
+	if got := doc.ComputedStyle(p)["color"]; got != "rgb(0, 0, 1)" {
+		t.Errorf("got %s for wide viewport", got)
+	}
+}
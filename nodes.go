@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"unicode"
 )
 
 // NodeType is a type of node
@@ -22,21 +23,35 @@ type Node struct {
 	Data       string
 	Children   []*Node
 	Attributes map[string]string
+	Parent     *Node
 }
 
 // NewTextNode creates it
 func NewTextNode(s string) *Node {
-	return &Node{TextNode, strings.TrimSpace(s), []*Node{}, make(map[string]string)}
+	return &Node{TextNode, strings.TrimSpace(s), []*Node{}, make(map[string]string), nil}
 }
 
 // NewElementNode creates it
 func NewElementNode(tagName string, attrs map[string]string, ch []*Node) *Node {
-	return &Node{ElementNode, tagName, ch, attrs}
+	return adoptChildren(&Node{ElementNode, tagName, ch, attrs, nil})
 }
 
 // NewRootNode creates a node without attributes and tag name
 func NewRootNode(ch []*Node) *Node {
-	return &Node{RootNode, "", ch, make(map[string]string)}
+	return adoptChildren(&Node{RootNode, "", ch, make(map[string]string), nil})
+}
+
+// newPseudoElementNode creates element node like "::before" for pseudo-element,
+// it is not in the children of its parent
+func newPseudoElementNode(parent *Node, pseudo string) *Node {
+	return &Node{ElementNode, "::" + pseudo, []*Node{}, make(map[string]string), parent}
+}
+
+func adoptChildren(n *Node) *Node {
+	for _, child := range n.Children {
+		child.Parent = n
+	}
+	return n
 }
 
 // GetID returns ID if it's present
@@ -59,6 +74,72 @@ func (n *Node) Class() *string {
 	return nil
 }
 
+// ClassList returns classes of the class attribute
+func (n *Node) ClassList() []string {
+	class := n.Class()
+	if class == nil {
+		return nil
+	}
+	return strings.Fields(*class)
+}
+
+// HasClass tells if the class attribute contains the class
+func (n *Node) HasClass(class string) bool {
+	list, ok := n.Attributes["class"]
+	for ok && list != "" {
+		i := strings.IndexFunc(list, unicode.IsSpace)
+		if i < 0 {
+			return list == class
+		} else if list[:i] == class {
+			return true
+		}
+		list = strings.TrimLeftFunc(list[i:], unicode.IsSpace)
+	}
+	return false
+}
+
+// PreviousElementSibling returns preceding element of the same parent or nil
+func (n *Node) PreviousElementSibling() *Node {
+	if n.Parent == nil {
+		return nil
+	}
+	var prev *Node
+	for _, sibling := range n.Parent.Children {
+		if sibling == n {
+			return prev
+		}
+		if sibling.NodeType == ElementNode {
+			prev = sibling
+		}
+	}
+	return nil
+}
+
+// NextElementSibling returns following element of the same parent or nil
+func (n *Node) NextElementSibling() *Node {
+	if n.Parent == nil {
+		return nil
+	}
+	found := false
+	for _, sibling := range n.Parent.Children {
+		if found && sibling.NodeType == ElementNode {
+			return sibling
+		}
+		if sibling == n {
+			found = true
+		}
+	}
+	return nil
+}
+
+// ParentElement returns parent if it is an element
+func (n *Node) ParentElement() *Node {
+	if n.Parent != nil && n.Parent.NodeType == ElementNode {
+		return n.Parent
+	}
+	return nil
+}
+
 // TagName returns tag of element node, empty if not element node
 func (n *Node) TagName() string {
 	return n.Data
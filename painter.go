@@ -16,8 +16,9 @@ type drawRect struct {
 }
 
 type drawText struct {
-	s  string
-	pt image.Point
+	s    string
+	font fontStyle
+	pt   image.Point
 }
 
 func (d *drawRect) draw(img *image.RGBA) {
@@ -30,7 +31,7 @@ func (d *drawRect) draw(img *image.RGBA) {
 }
 
 func (d *drawText) draw(img *image.RGBA) {
-	drawString(d.s, img, d.pt)
+	drawString(d.s, d.font, img, d.pt)
 }
 
 func mergeLists(l1 []drawCommand, l2 []drawCommand) []drawCommand {
@@ -50,7 +51,7 @@ func makeDisplayList(layout *layoutBox) []drawCommand {
 		d := &drawRect{v.color, layout.dimensions.paddingBox()}
 		commands = append(commands, d)
 	} else if layout.boxType == textBox {
-		d := &drawText{layout.styledNode.node.Data, layout.dimensions.content.min()}
+		d := &drawText{layout.styledNode.node.Data, layout.fontStyle(), layout.dimensions.content.min()}
 		commands = append(commands, d)
 	}
 
@@ -67,10 +68,13 @@ func drawDisplayList(img *image.RGBA, commands []drawCommand) {
 	}
 }
 
+// layoutAndDraw lays out and draws the root box, the image is blank without it
 func layoutAndDraw(rootBox *layoutBox, width int, height int) *image.RGBA {
-	rootBox.layoutRoot(width, height)
-	commands := makeDisplayList(rootBox)
 	img := image.NewRGBA(image.Rect(0, 0, width, height))
-	drawDisplayList(img, commands)
+	if rootBox == nil {
+		return img
+	}
+	rootBox.layoutRoot(width, height)
+	drawDisplayList(img, makeDisplayList(rootBox))
 	return img
 }
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStyleTreeParallel(t *testing.T) {
+	html, css := generatedPage(2000)
+	css += `li:first-child a {color: #000001} li + li {margin-top: 1em}
+		div:has(> ul) {counter-reset: item} li::before {counter-increment: item; content: counter(item) ". "}`
+	node, err := parseHTMLWrapped(strings.NewReader(html))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sheet, err := ParseStylesheet(strings.NewReader(css))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sheets := []*Stylesheet{UserAgentStylesheet(), sheet}
+	want := styleTree(node, sheets...)
+	for _, threshold := range []int{1, 10, 100} {
+		got := styleTreeMedia(node, newMediaEnvironment(800, 600, RenderOptions{Workers: 4, ParallelThreshold: threshold}), sheets...)
+		var compare func(a, b *styledNode)
+		compare = func(a, b *styledNode) {
+			if a.node.TagName() != b.node.TagName() || !reflect.DeepEqual(a.computedValues, b.computedValues) ||
+				!reflect.DeepEqual(a.firstLine, b.firstLine) || len(a.children) != len(b.children) {
+				t.Fatalf("threshold %d: styles of %s differ: %v and %v", threshold, a.node.TagName(), a.computedValues, b.computedValues)
+			}
+			if a.pseudo != "" && generatedText(a) != generatedText(b) {
+				t.Fatalf("threshold %d: generated content differs", threshold)
+			}
+			for i := range a.children {
+				compare(a.children[i], b.children[i])
+			}
+		}
+		compare(want, got)
+	}
+}
+
+func TestStyleTreeParallelMedia(t *testing.T) {
+	html, css := generatedPage(1000)
+	css += `@media (max-width: 500px) { li a {color: #000001} .section {display: none} }
+		@media print { ul > li {margin-left: 2em} }`
+	node, err := parseHTMLWrapped(strings.NewReader(html))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sheet, err := ParseStylesheet(strings.NewReader(css))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := styleTreeMedia(node, newMediaEnvironment(400, 300, RenderOptions{MediaType: "print"}), sheet)
+	got := styleTreeMedia(node, newMediaEnvironment(400, 300, RenderOptions{MediaType: "print", Workers: 4, ParallelThreshold: 10}), sheet)
+	compareStyledTrees(t, "parallel", want, got)
+	section := got.children[0].children[0]
+	if !section.computedValues["display"].isKeyword("none") {
+		t.Errorf("@media rules aren't applied: section has display %v", section.computedValues["display"])
+	}
+}
+
+func TestStyledDocumentParallel(t *testing.T) {
+	html, css := generatedPage(1000)
+	sheet, err := ParseStylesheet(strings.NewReader(css))
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := parseHTMLWrapped(strings.NewReader(html))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := NewStyledDocument(node, UserAgentStylesheet(), sheet)
+	got := NewStyledDocumentWithOptions(node, RenderOptions{Workers: 4, ParallelThreshold: 10}, UserAgentStylesheet(), sheet)
+	if got.media.Workers != 4 || got.media.MediaType != "screen" {
+		t.Errorf("options are not used: %+v", got.media.RenderOptions)
+	}
+	compareStyledTrees(t, "document", want.root, got.root)
+}
+
+func BenchmarkStyleTreeParallel(b *testing.B) {
+	html, css := generatedPage(10000)
+	node, err := parseHTMLWrapped(strings.NewReader(html))
+	if err != nil {
+		b.Fatal(err)
+	}
+	sheet, err := ParseStylesheet(strings.NewReader(css))
+	if err != nil {
+		b.Fatal(err)
+	}
+	media := newMediaEnvironment(800, 600, RenderOptions{Workers: 8, ParallelThreshold: 100})
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		styleTreeMedia(node, media, sheet)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import "image/color"
+
+// Property registry and computed values, https://www.w3.org/TR/css-cascade-4/#value-stages
+
+// propertyDef describes property of the registry
+type propertyDef struct {
+	initial   Value
+	inherited bool
+}
+
+const defaultFontSize = 16
+
+// properties are known properties with their initial values.
+// Properties out of the registry keep specified values and don't inherit,
+// layout falls back to shorthands and defaults for missing ones.
+var properties = map[string]propertyDef{
+	"color":             {colorValue(color.RGBA{0, 0, 0, 255}), true},
+	"font-size":         {pxValue(defaultFontSize), true},
+	"font-family":       {keywordValue("serif"), true},
+	"font-style":        {keywordValue("normal"), true},
+	"font-weight":       {keywordValue("normal"), true},
+	"line-height":       {keywordValue("normal"), true},
+	"letter-spacing":    {keywordValue("normal"), true},
+	"word-spacing":      {keywordValue("normal"), true},
+	"text-align":        {keywordValue("start"), true},
+	"text-indent":       {pxValue(0), true},
+	"text-transform":    {keywordValue("none"), true},
+	"white-space":       {keywordValue("normal"), true},
+	"visibility":        {keywordValue("visible"), true},
+	"direction":         {keywordValue("ltr"), true},
+	"list-style-type":   {keywordValue("disc"), true},
+	"quotes":            {keywordValue("auto"), true},
+	"border-collapse":   {keywordValue("separate"), true},
+	"border-spacing":    {pxValue(0), true},
+	"display":           {keywordValue("inline"), false},
+	"width":             {keywordValue("auto"), false},
+	"height":            {keywordValue("auto"), false},
+	"float":             {keywordValue("none"), false},
+	"vertical-align":    {keywordValue("baseline"), false},
+	"text-decoration":   {keywordValue("none"), false},
+	"background-color":  {colorValue(color.RGBA{}), false},
+	"content":           {keywordValue("normal"), false},
+	"counter-reset":     {keywordValue("none"), false},
+	"counter-increment": {keywordValue("none"), false},
+	"container-type":    {keywordValue("normal"), false},
+	"container-name":    {keywordValue("none"), false},
+}
+
+// lengthProperties take lengths, their unitless zero is 0px, https://www.w3.org/TR/css-values-4/#zero-value
+var lengthProperties = map[string]bool{
+	"margin": true, "margin-top": true, "margin-right": true, "margin-bottom": true, "margin-left": true,
+	"padding": true, "padding-top": true, "padding-right": true, "padding-bottom": true, "padding-left": true,
+	"border": true, "border-top": true, "border-right": true, "border-bottom": true, "border-left": true,
+	"border-width": true, "border-top-width": true, "border-right-width": true, "border-bottom-width": true, "border-left-width": true,
+	"width": true, "height": true, "min-width": true, "min-height": true, "max-width": true, "max-height": true,
+	"top": true, "right": true, "bottom": true, "left": true,
+	"font-size": true, "text-indent": true, "letter-spacing": true, "word-spacing": true,
+	"border-spacing": true, "vertical-align": true,
+}
+
+// absoluteFontSizes are sizes of font-size keywords in px
+var absoluteFontSizes = map[string]float32{
+	"xx-small": 9, "x-small": 10, "small": 13, "medium": 16,
+	"large": 18, "x-large": 24, "xx-large": 32, "xxx-large": 48,
+}
+
+// fontSizeRatio is the step of smaller and larger font-size keywords
+const fontSizeRatio = 1.2
+
+// computeValues resolves specified values of the node against computed values
+// of its parent: custom properties and var(), inherit, initial and unset keywords,
+// missing properties, and font-relative lengths, which become px.
+// parent is nil for the root.
+func computeValues(specified, parent propertyMap, rootFontSize float32) propertyMap {
+	specified, custom := substituteVariables(specified, parent)
+	computed := make(propertyMap, len(properties)+len(specified)+1)
+	if len(custom) > 0 {
+		computed[customPropertiesKey] = Value{valueType: CustomProperties, vars: custom}
+	}
+	for name, def := range properties {
+		v, ok := specified[name]
+		switch {
+		case !ok || v.isKeyword("unset") && def.inherited || v.isKeyword("inherit"):
+			if p, ok := parent[name]; ok && (def.inherited || v.isKeyword("inherit")) {
+				computed[name] = p
+			} else {
+				computed[name] = def.initial
+			}
+		case v.isKeyword("initial") || v.isKeyword("unset"):
+			computed[name] = def.initial
+		default:
+			computed[name] = zeroToLength(name, v)
+		}
+	}
+	for name, v := range specified {
+		if _, ok := properties[name]; ok {
+			continue
+		}
+		if v.isKeyword("inherit") {
+			if p, ok := parent[name]; ok {
+				computed[name] = p
+			}
+		} else if !v.isKeyword("initial") && !v.isKeyword("unset") {
+			computed[name] = zeroToLength(name, v)
+		}
+	}
+
+	parentFontSize := float32(defaultFontSize)
+	if p, ok := parent["font-size"]; ok {
+		parentFontSize = p.toPx()
+	}
+	fontSize := computeFontSize(computed["font-size"], parentFontSize, rootFontSize)
+	computed["font-size"] = pxValue(fontSize)
+	parentColor, ok := parent["color"]
+	if !ok {
+		parentColor = properties["color"].initial
+	}
+	computed["color"] = currentColor(computed["color"], parentColor)
+	for name, v := range computed {
+		if name == "line-height" && v.valueType == Length && v.unitType == Percent {
+			computed[name] = pxValue(v.length * fontSize / 100)
+		} else if v.valueType == ListValue || v.valueType == Length && fontRelativeUnits[v.unitType] {
+			v = absoluteLength(v, fontSize, rootFontSize)
+			computed[name] = v
+		}
+		if colorProperties[name] && name != "color" {
+			computed[name] = currentColor(v, computed["color"])
+		}
+	}
+	return computed
+}
+
+// inheritedValues returns computed values of a node without own declarations,
+// like text, inside parent
+func inheritedValues(parent propertyMap) propertyMap {
+	return computeValues(nil, parent, defaultFontSize)
+}
+
+// computeFontSize converts font-size to px, relative sizes are of the parent font
+func computeFontSize(v Value, parentFontSize, rootFontSize float32) float32 {
+	switch v.valueType {
+	case Keyword:
+		if size, ok := absoluteFontSizes[v.keyword]; ok {
+			return size
+		}
+		switch v.keyword {
+		case "smaller":
+			return parentFontSize / fontSizeRatio
+		case "larger":
+			return parentFontSize * fontSizeRatio
+		}
+	case Length:
+		if v.unitType == Percent {
+			return v.length * parentFontSize / 100
+		}
+		return absoluteLength(v, parentFontSize, rootFontSize).toPx()
+	}
+	return parentFontSize
+}
+
+// zeroToLength returns the value with unitless zeros as 0px if the property takes lengths
+func zeroToLength(name string, v Value) Value {
+	if !lengthProperties[name] {
+		return v
+	}
+	switch v.valueType {
+	case Number:
+		if v.length == 0 {
+			return pxValue(0)
+		}
+	case ListValue:
+		list := make([]Value, len(v.list))
+		for i, item := range v.list {
+			list[i] = item
+			if item.valueType == Number && item.length == 0 {
+				list[i] = pxValue(0)
+			}
+		}
+		v.list = list
+	}
+	return v
+}
+
+var fontRelativeUnits = map[UnitType]bool{Em: true, Ex: true, Ch: true, Rem: true}
+
+// absoluteLength converts font-relative lengths of the value to px,
+// ex and ch are taken as half of em
+func absoluteLength(v Value, fontSize, rootFontSize float32) Value {
+	switch v.valueType {
+	case Length:
+		switch v.unitType {
+		case Em:
+			return pxValue(v.length * fontSize)
+		case Ex, Ch:
+			return pxValue(v.length * fontSize / 2)
+		case Rem:
+			return pxValue(v.length * rootFontSize)
+		}
+	case ListValue:
+		list := make([]Value, len(v.list))
+		for i, item := range v.list {
+			list[i] = absoluteLength(item, fontSize, rootFontSize)
+		}
+		v.list = list
+	}
+	return v
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_computeValues(t *testing.T) {
+	computedOf := func(html string, sheets ...*Stylesheet) *styledNode {
+		node, err := parseHTMLWrapped(strings.NewReader(html))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return styleTree(node, sheets...)
+	}
+	sheet := func(css string, origin Origin) *Stylesheet {
+		s, err := ParseStylesheetOrigin(strings.NewReader(css), origin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	t.Run("inherited properties reach text", func(t *testing.T) {
+		st := computedOf(`<body><p>text</p></body>`, sheet(`body {color: #ff0000; font-size: 20px; background-color: #00ff00}`, AuthorOrigin))
+		p := st.children[0].children[0]
+		text := p.children[0]
+		if text.computedValues["color"].color.R != 255 || text.computedValues["font-size"].toPx() != 20 {
+			t.Error("color and font-size should inherit", text.computedValues)
+		}
+		if p.computedValues["background-color"].color.G != 0 {
+			t.Error("background-color should not inherit")
+		}
+		if !p.computedValues["display"].isKeyword("inline") {
+			t.Error("display should be initial", p.computedValues["display"])
+		}
+	})
+	t.Run("font-relative lengths", func(t *testing.T) {
+		st := computedOf(`<html><div><p>x</p></div></html>`,
+			sheet(`html {font-size: 10px} div {font-size: 2em; margin-left: 1em} p {font-size: 150%; padding-left: 2rem; margin: 1em 0}`, AuthorOrigin))
+		div := st.children[0].children[0]
+		p := div.children[0]
+		checks := []struct {
+			name      string
+			got, want float32
+		}{
+			{"div font-size", div.computedValues["font-size"].toPx(), 20},
+			{"div margin", div.computedValues["margin-left"].toPx(), 20},
+			{"p font-size", p.computedValues["font-size"].toPx(), 30},
+			{"p rem", p.computedValues["padding-left"].toPx(), 20},
+			{"p shorthand", p.computedValues["margin"].list[0].toPx(), 30},
+		}
+		for _, c := range checks {
+			if c.got != c.want {
+				t.Errorf("%s = %v, want %v", c.name, c.got, c.want)
+			}
+		}
+	})
+	t.Run("font-size keywords", func(t *testing.T) {
+		st := computedOf(`<div><small>x</small></div>`, sheet(`div {font-size: x-large} small {font-size: smaller}`, AuthorOrigin))
+		if got := st.children[0].children[0].computedValues["font-size"].toPx(); got != 20 {
+			t.Error(got)
+		}
+	})
+	t.Run("inherit initial unset", func(t *testing.T) {
+		st := computedOf(`<div><p>a</p><em>b</em><b>c</b></div>`,
+			sheet(`div {display: block; color: #ff0000; border-width: 3px} p {color: initial; border-width: inherit} em {color: unset; display: unset} b {display: inherit}`, AuthorOrigin))
+		div := st.children[0]
+		p, em, b := div.children[0], div.children[1], div.children[2]
+		if p.computedValues["color"].color.R != 0 || p.computedValues["border-width"].toPx() != 3 {
+			t.Error("wrong inherit or initial", p.computedValues)
+		}
+		if em.computedValues["color"].color.R != 255 || !em.computedValues["display"].isKeyword("inline") {
+			t.Error("wrong unset", em.computedValues)
+		}
+		if !b.computedValues["display"].isKeyword("block") {
+			t.Error("display should be inherited", b.computedValues["display"])
+		}
+	})
+	t.Run("revert rolls back to the previous origin", func(t *testing.T) {
+		ua := sheet(`p {display: block; color: #000001}`, UserAgentOrigin)
+		user := sheet(`p {color: #000002}`, UserOrigin)
+		st := computedOf(`<div><p>x</p></div>`, ua, user,
+			sheet(`div {color: #000003} p {display: revert; color: revert} p {color: #000004}`, AuthorOrigin))
+		p := st.children[0].children[0]
+		if !p.computedValues["display"].isKeyword("block") || p.computedValues["color"].color.B != 4 {
+			t.Error("wrong revert", p.computedValues)
+		}
+		st = computedOf(`<div><p>x</p></div>`, ua, user, sheet(`p {color: revert}`, AuthorOrigin))
+		if got := st.children[0].children[0].computedValues["color"].color.B; got != 2 {
+			t.Error("should revert to user value, got", got)
+		}
+		st = computedOf(`<div><p>x</p></div>`, sheet(`p {color: revert}`, UserAgentOrigin), sheet(`div {color: #000003}`, AuthorOrigin))
+		if got := st.children[0].children[0].computedValues["color"].color.B; got != 3 {
+			t.Error("revert of user agent should inherit, got", got)
+		}
+	})
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Resources of stylesheets: imported sheets, https://www.w3.org/TR/css-cascade-4/#at-import
+// and fonts, https://www.w3.org/TR/css-fonts-4/#font-face-rule
+
+// ResourceLoader loads resources of documents: linked and imported stylesheets and fonts
+type ResourceLoader interface {
+	// Load returns content of the resource, url is resolved against the referring sheet
+	Load(url string) ([]byte, error)
+}
+
+// DirLoader loads resources from files, urls are paths relative to the directory
+type DirLoader string
+
+// Load reads the file of the url. Documents may come from anywhere, like archived emails,
+// so urls out of the directory, like ../secret or /etc/passwd, are rejected.
+func (d DirLoader) Load(url string) ([]byte, error) {
+	name := filepath.FromSlash(url)
+	if !filepath.IsLocal(name) {
+		return nil, fmt.Errorf("%s is out of directory %s", url, string(d))
+	}
+	return os.ReadFile(filepath.Join(string(d), name))
+}
+
+// importRule is like @import url("print.css") print, sheet is nil until it is loaded
+type importRule struct {
+	url   string
+	media mediaQueryList
+	sheet *Stylesheet
+}
+
+// fontFace is @font-face rule, its descriptors are kept as declarations,
+// font is its loaded source, nil until it is loaded
+type fontFace struct {
+	declarators []*Declarator
+	font        parsedFont
+}
+
+// fontSource is url of src descriptor, format is empty if it isn't given
+type fontSource struct {
+	url    string
+	format string
+}
+
+// fontFormats are formats of src, which the font subsystem reads
+var fontFormats = map[string]bool{"truetype": true, "opentype": true}
+
+// LoadStylesheet loads the sheet at url, like a linked one, along with its resources
+func LoadStylesheet(loader ResourceLoader, url string, origin Origin) (*Stylesheet, error) {
+	data, err := loader.Load(url)
+	if err != nil {
+		return nil, err
+	}
+	sheet, err := ParseStylesheetOrigin(bytes.NewReader(data), origin)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", url, err)
+	}
+	sheet.href = url
+	return sheet, sheet.LoadResources(loader)
+}
+
+// LoadResources loads sheets imported by the sheet and fonts of @font-face rules
+// of all of them, relative urls are resolved against href of the referring sheet.
+// Failed imports, and those which would make a cycle, are skipped, as well as
+// fonts, which fail to load. The error tells about them.
+func (s *Stylesheet) LoadResources(loader ResourceLoader) error {
+	errs := s.loadImports(loader, map[string]bool{s.href: true}, conditions{})
+	for _, sheet := range withImports([]*Stylesheet{s}) {
+		for _, face := range sheet.fontFaces {
+			if err := face.load(loader, sheet.href); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// loadImports loads imported sheets recursively, loading has urls of sheets being loaded,
+// outer are conditions of the sheet, which are conditions of the imports it is loaded by
+func (s *Stylesheet) loadImports(loader ResourceLoader, loading map[string]bool, outer conditions) []error {
+	errs := []error{}
+	for _, imp := range s.imports {
+		url := resolveURL(s.href, imp.url)
+		if loading[url] {
+			errs = append(errs, fmt.Errorf("%s: import of %s makes a cycle", s.href, url))
+			continue
+		}
+		data, err := loader.Load(url)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		inner := outer
+		if len(imp.media) > 0 {
+			inner.media = append(outer.media[:len(outer.media):len(outer.media)], imp.media)
+		}
+		sheet, err := parseStylesheet(bytes.NewReader(data), s.origin, inner)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", url, err))
+			continue
+		}
+		sheet.href = url
+		loading[url] = true
+		errs = append(errs, sheet.loadImports(loader, loading, inner)...)
+		delete(loading, url)
+		imp.sheet = sheet
+	}
+	return errs
+}
+
+// withImports returns the sheets, each preceded by sheets it imports, in the order of the cascade
+func withImports(sheets []*Stylesheet) []*Stylesheet {
+	result := []*Stylesheet{}
+	for _, sheet := range sheets {
+		for _, imp := range sheet.imports {
+			if imp.sheet != nil {
+				result = append(result, withImports([]*Stylesheet{imp.sheet})...)
+			}
+		}
+		result = append(result, sheet)
+	}
+	return result
+}
+
+// rulesWithImports returns rules of the sheet and of sheets it imports
+func rulesWithImports(sheet *Stylesheet) []*Rule {
+	rules := []*Rule{}
+	for _, s := range withImports([]*Stylesheet{sheet}) {
+		rules = append(rules, s.rules...)
+	}
+	return rules
+}
+
+// resolveURL resolves url of a resource referred by the sheet at base
+func resolveURL(base, ref string) string {
+	if strings.Contains(base, "://") {
+		if b, err := url.Parse(base); err == nil {
+			if r, err := url.Parse(ref); err == nil {
+				return b.ResolveReference(r).String()
+			}
+		}
+	}
+	if strings.Contains(ref, "://") || strings.HasPrefix(ref, "/") {
+		return ref
+	}
+	return path.Join(path.Dir(base), ref)
+}
+
+// load loads the first source of a known format, which documents with the sheet
+// register as the font of the family
+func (f *fontFace) load(loader ResourceLoader, base string) error {
+	family := f.family()
+	if family == "" {
+		return fmt.Errorf("@font-face has no font-family")
+	}
+	errs := []error{}
+	for _, src := range f.sources() {
+		if src.format != "" && !fontFormats[src.format] {
+			continue
+		}
+		url := resolveURL(base, src.url)
+		data, err := loader.Load(url)
+		if err == nil {
+			f.font, err = parseFont(data)
+		}
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("font %s from %s: %w", family, url, err))
+	}
+	if len(errs) == 0 {
+		return fmt.Errorf("font %s has no source of known format", family)
+	}
+	return errors.Join(errs...)
+}
+
+func (f *fontFace) descriptor(name string) (Value, bool) {
+	for i := len(f.declarators) - 1; i >= 0; i-- {
+		if f.declarators[i].name == name {
+			return f.declarators[i].value, true
+		}
+	}
+	return Value{}, false
+}
+
+// family is the name of font-family
+func (f *fontFace) family() string {
+	v, _ := f.descriptor("font-family")
+	return familyName(v)
+}
+
+// familyName returns the family name of the value, unquoted names of several words
+// are joined by spaces, empty if the value isn't a name
+func familyName(v Value) string {
+	switch v.valueType {
+	case StringValue:
+		return v.text
+	case Keyword:
+		return v.keyword
+	case ListValue:
+		words := []string{}
+		for _, word := range v.list {
+			if word.valueType != Keyword || v.commas {
+				return ""
+			}
+			words = append(words, word.keyword)
+		}
+		return strings.Join(words, " ")
+	}
+	return ""
+}
+
+// sources returns urls of src descriptor with their formats
+func (f *fontFace) sources() []fontSource {
+	v, ok := f.descriptor("src")
+	if !ok {
+		return nil
+	}
+	items := []Value{v}
+	if v.valueType == ListValue && v.commas {
+		items = v.list
+	}
+	sources := []fontSource{}
+	for _, item := range items {
+		parts := []Value{item}
+		if item.valueType == ListValue {
+			parts = item.list
+		}
+		src := fontSource{}
+		for _, part := range parts {
+			if part.valueType != FunctionValue || len(part.args) != 1 || part.args[0].valueType != StringValue {
+				continue
+			}
+			switch part.function {
+			case "url":
+				src.url = part.args[0].text
+			case "format":
+				src.format = strings.ToLower(part.args[0].text)
+			}
+		}
+		if src.url != "" {
+			sources = append(sources, src)
+		}
+	}
+	return sources
+}
+
+// weight returns font-weight as a number
+func (f *fontFace) weight() int {
+	v, _ := f.descriptor("font-weight")
+	return fontWeight(v)
+}
+
+// italic tells if font-style is italic or oblique
+func (f *fontFace) italic() bool {
+	v, _ := f.descriptor("font-style")
+	return isItalic(v)
+}
+
+// documentFonts returns the fonts of a document with the sheets: faces of their loaded
+// @font-face rules and those of sheets they import, later rules replace faces
+// of the same family, weight and style
+func documentFonts(sheets []*Stylesheet) fontRegistry {
+	fonts := make(fontRegistry)
+	for _, sheet := range withImports(sheets) {
+		for _, face := range sheet.fontFaces {
+			if face.font != nil {
+				fonts.register(face.family(), face.weight(), face.italic(), face.font)
+			}
+		}
+	}
+	return fonts
+}
+
+// fontWeight returns font-weight as a number, 400 for normal
+func fontWeight(v Value) int {
+	switch {
+	case v.isKeyword("bold") || v.isKeyword("bolder"):
+		return 700
+	case v.isKeyword("lighter"):
+		return 100
+	case v.valueType == Number:
+		return int(v.length)
+	}
+	return 400
+}
+
+func isItalic(v Value) bool {
+	return v.isKeyword("italic") || v.isKeyword("oblique")
+}
+
+// fontStyle selects the face of text among faces registered by @font-face rules,
+// size is font-size in px, the default size if it is 0
+type fontStyle struct {
+	// families are names of font-family in the order of preference
+	families []string
+	weight   int
+	italic   bool
+	size     float32
+	// fonts are those of the document, without them text has the default font
+	fonts fontRegistry
+}
+
+// fontStyleOf returns the font style of computed values
+func fontStyleOf(computed propertyMap) fontStyle {
+	style := fontStyle{
+		weight: fontWeight(computed["font-weight"]),
+		italic: isItalic(computed["font-style"]),
+		size:   computed["font-size"].toPx(),
+	}
+	family := computed["font-family"]
+	items := []Value{family}
+	if family.valueType == ListValue && family.commas {
+		items = family.list
+	}
+	for _, item := range items {
+		if name := familyName(item); name != "" {
+			style.families = append(style.families, name)
+		}
+	}
+	return style
+}
+
+// parseImport parses url and media queries of @import up to ;
+func parseImport(r *bufio.Reader) (*importRule, error) {
+	skipSpaces(r)
+	v, err := parseComponent(r)
+	if err != nil {
+		return nil, err
+	}
+	imp := &importRule{}
+	switch {
+	case v.valueType == StringValue:
+		imp.url = v.text
+	case v.valueType == FunctionValue && v.function == "url" && len(v.args) == 1 && v.args[0].valueType == StringValue:
+		imp.url = v.args[0].text
+	default:
+		return nil, fmt.Errorf("url is expected after @import")
+	}
+	imp.media = parseMediaQueryList(r)
+	if !consumeRequired(r, ';') && !isNextCharEOF(r) {
+		return nil, fmt.Errorf("; is expected after @import")
+	}
+	return imp, nil
+}
+
+// String serializes the rule like @import url("print.css") print;
+func (imp *importRule) String() string {
+	return imp.format(false)
+}
+
+func (imp *importRule) format(minify bool) string {
+	s := "@import url(" + quoteString(imp.url) + ")"
+	if len(imp.media) > 0 {
+		s += " " + imp.media.format(minify)
+	}
+	return s + ";"
+}
+
+// String serializes the rule like @font-face { font-family: "Brand"; src: url("brand.ttf"); }
+func (f *fontFace) String() string {
+	return "@font-face " + formatBlock(f.declarators, false)
+}
+
+// formatBlock serializes declarations in braces
+func formatBlock(declarators []*Declarator, minify bool) string {
+	parts := []string{}
+	for _, d := range declarators {
+		parts = append(parts, d.format(minify))
+	}
+	if minify {
+		return "{" + strings.Join(parts, ";") + "}"
+	}
+	if len(parts) == 0 {
+		return "{ }"
+	}
+	return "{ " + strings.Join(parts, "; ") + "; }"
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// mapLoader serves resources from memory and records loaded urls
+type mapLoader struct {
+	files  map[string]string
+	loaded []string
+}
+
+func (l *mapLoader) Load(url string) ([]byte, error) {
+	l.loaded = append(l.loaded, url)
+	content, ok := l.files[url]
+	if !ok {
+		return nil, fmt.Errorf("%s is not found", url)
+	}
+	return []byte(content), nil
+}
+
+func TestLoadStylesheet(t *testing.T) {
+	loader := &mapLoader{files: map[string]string{
+		"css/main.css": `@charset "utf-8"; @import url(base.css); @import "print.css" print;
+			@import url("../missing.css");
+			em {color: #000003} @import url(late.css);`,
+		"css/base.css":      `@import "main.css"; @import url(/shared/reset.css); p {color: #000001; margin-top: 1px}`,
+		"css/print.css":     `@import "more.css" (min-width: 100px); p {color: #000002}`,
+		"css/more.css":      `p {word-spacing: 3px}`,
+		"/shared/reset.css": `p {margin-top: 0; text-indent: 2px}`,
+	}}
+	sheet, err := LoadStylesheet(loader, "css/main.css", AuthorOrigin)
+	if sheet == nil {
+		t.Fatal(err)
+	}
+	if err == nil || !strings.Contains(err.Error(), "cycle") || !strings.Contains(err.Error(), "missing.css is not found") {
+		t.Errorf("got error %v", err)
+	}
+	for _, url := range loader.loaded {
+		if url == "css/late.css" || url == "css/main.css" && loader.loaded[0] != url {
+			t.Errorf("%s is loaded", url)
+		}
+	}
+	hrefs := []string{}
+	for _, s := range withImports([]*Stylesheet{sheet}) {
+		hrefs = append(hrefs, s.href)
+	}
+	if got := strings.Join(hrefs, " "); got != "/shared/reset.css css/base.css css/more.css css/print.css css/main.css" {
+		t.Errorf("got sheets %s", got)
+	}
+
+	tests := []struct {
+		media   RenderOptions
+		color   string
+		spacing string
+	}{
+		{RenderOptions{}, "rgb(0, 0, 1)", "normal"},
+		{RenderOptions{MediaType: "print"}, "rgb(0, 0, 2)", "3px"},
+	}
+	for _, tt := range tests {
+		node, err := parseHTMLWrapped(strings.NewReader(`<p>1</p>`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc := NewStyledDocument(node, sheet)
+		doc.SetRenderOptions(tt.media)
+		style := doc.ComputedStyle(node.Children[0])
+		if style["color"] != tt.color || style["text-indent"] != "2px" || style["word-spacing"] != tt.spacing {
+			t.Errorf("%v: got color %s, indent %s, word spacing %s", tt.media, style["color"], style["text-indent"], style["word-spacing"])
+		}
+	}
+	sheets := withImports([]*Stylesheet{sheet})
+	if rule := sheets[3].rules[0]; len(rule.media) != 1 || rule.media[0].String() != "print" {
+		t.Errorf("imported rule has media %v", rule.media)
+	}
+	if rule := sheets[2].rules[0]; len(rule.media) != 2 || rule.media[0].String() != "print" || rule.media[1].String() != "(min-width: 100px)" {
+		t.Errorf("rule of nested import has media %v", rule.media)
+	}
+	if got := sheet.imports[1].String(); got != `@import url("print.css") print;` {
+		t.Errorf("import is serialized as %s", got)
+	}
+}
+
+func TestFontFace(t *testing.T) {
+	css := `@font-face {font-family: "Brand Sans"; src: url(brand.woff2) format("woff2"), url(brand.ttf) format("truetype"), url(brand.otf);
+			font-weight: bold; font-style: italic}
+		@font-face {font-family: Brand Serif; src: url(missing.ttf), url("serif.otf") format("opentype")}
+		@font-face {src: url(x.ttf)}
+		@media print { @font-face {font-family: Print; src: url(print.ttf)} }
+		p {font-family: "Brand Sans"}`
+	sheet := mustParseStylesheet(t, css)
+	if len(sheet.fontFaces) != 3 || len(sheet.rules) != 1 {
+		t.Fatalf("got %d font faces, %d rules", len(sheet.fontFaces), len(sheet.rules))
+	}
+	face := sheet.fontFaces[0]
+	if face.family() != "Brand Sans" || face.weight() != 700 || !face.italic() || len(face.sources()) != 3 {
+		t.Errorf("got font face %s, %d, %v, %v", face.family(), face.weight(), face.italic(), face.sources())
+	}
+	if serif := sheet.fontFaces[1]; serif.family() != "Brand Serif" || serif.weight() != 400 || serif.italic() {
+		t.Errorf("got font face %s", serif)
+	}
+	data, err := os.ReadFile("fonts/PlayfairDisplay-Regular.ttf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loader := &mapLoader{files: map[string]string{"fonts/brand.ttf": string(data), "fonts/serif.otf": string(data)}}
+	sheet.href = "fonts/style.css"
+	err = sheet.LoadResources(loader)
+	if got := strings.Join(loader.loaded, " "); got != "fonts/brand.ttf fonts/missing.ttf fonts/serif.otf" {
+		t.Errorf("got fonts %s", got)
+	}
+	if err == nil || !strings.Contains(err.Error(), "no font-family") || strings.Contains(err.Error(), "serif") {
+		t.Errorf("got error %v", err)
+	}
+	fonts := documentFonts([]*Stylesheet{sheet})
+	if len(fonts) != 2 || len(fonts["brand sans"]) != 1 || len(fonts["brand serif"]) != 1 {
+		t.Errorf("document has fonts %v", fonts)
+	}
+	if fonts := documentFonts([]*Stylesheet{mustParseStylesheet(t, css)}); len(fonts) != 0 {
+		t.Errorf("fonts of another document leak: %v", fonts)
+	}
+	node, err := parseHTMLWrapped(strings.NewReader(`<p>text</p>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := NewStyledDocument(node, sheet)
+	doc.Layout(800, 600)
+	if text := doc.boxes[node.Children[0].Children[0]]; text == nil || len(text.fontStyle().fonts["brand sans"]) != 1 {
+		t.Error("text is not laid out with fonts of its document")
+	}
+	if got := face.String(); got != `@font-face { font-family: "Brand Sans"; src: url("brand.woff2") format("woff2"), url("brand.ttf") format("truetype"), url("brand.otf"); font-weight: bold; font-style: italic; }` {
+		t.Errorf("font face is serialized as %s", got)
+	}
+}
+
+func TestDirLoader(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "css"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "css", "main.css"), []byte("p {color: red}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	loader := DirLoader(filepath.Join(dir, "css"))
+	if data, err := loader.Load("main.css"); err != nil || string(data) != "p {color: red}" {
+		t.Errorf("got %q, %v", data, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "secret"), []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, url := range []string{"../secret", "fonts/../../secret", filepath.ToSlash(filepath.Join(dir, "secret")), ""} {
+		if data, err := loader.Load(url); err == nil {
+			t.Errorf("%s is loaded as %q", url, data)
+		}
+	}
+}
+
+func Test_fontStyleOf(t *testing.T) {
+	styled := styleString(t, `<p>a<em>b</em></p>`,
+		`p {font-family: "Brand Sans", Brand Serif, serif; font-weight: 600; font-size: 20px} em {font-style: oblique; font-weight: bold}`)
+	p := styled.children[0]
+	want := fontStyle{families: []string{"Brand Sans", "Brand Serif", "serif"}, weight: 600, size: 20}
+	if got := fontStyleOf(p.children[0].computedValues); !reflect.DeepEqual(got, want) {
+		t.Errorf("text of p has font %+v, want %+v", got, want)
+	}
+	want.weight, want.italic = 700, true
+	if got := fontStyleOf(p.children[1].computedValues); !reflect.DeepEqual(got, want) {
+		t.Errorf("em has font %+v, want %+v", got, want)
+	}
+}
+
+func Test_resolveURL(t *testing.T) {
+	tests := []struct {
+		base, ref, want string
+	}{
+		{"", "a.css", "a.css"},
+		{"css/main.css", "../fonts/a.ttf", "fonts/a.ttf"},
+		{"css/main.css", "/a.css", "/a.css"},
+		{"https://example.com/css/main.css", "../a.css", "https://example.com/a.css"},
+		{"css/main.css", "https://example.com/a.css", "https://example.com/a.css"},
+	}
+	for _, tt := range tests {
+		if got := resolveURL(tt.base, tt.ref); got != tt.want {
+			t.Errorf("%s from %s: got %s, want %s", tt.ref, tt.base, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Incremental restyle. Changes of the document and its sheets mark styled nodes dirty,
+// which elements are marked depends on the selectors of the sheets, see invalidationMap.
+// Restyle matches rules again only for marked elements, the rest of elements
+// compute their values again if values of their parents have changed.
+
+// restyleHint tells which elements must match rules again
+type restyleHint uint8
+
+const (
+	// restyleSelf is for the element itself
+	restyleSelf restyleHint = 1 << iota
+	// restyleDescendants is for all its descendants
+	restyleDescendants
+	// restyleSiblings is for its following siblings,
+	// and their descendants if restyleDescendants is set too
+	restyleSiblings
+	// recomputeSelf and recomputeDescendants are passed down by Restyle,
+	// when values of the parent or the root font size have changed
+	recomputeSelf
+	recomputeDescendants
+)
+
+// invalidation is what a change of the element invalidates
+type invalidation struct {
+	hint restyleHint
+	// has is the hint for the element ancestors and their preceding siblings,
+	// which :has() may be anchored at
+	has restyleHint
+}
+
+func (inv *invalidation) add(other invalidation) {
+	inv.hint |= other.hint
+	inv.has |= other.has
+}
+
+// invalidationMap keeps what changes of ids, classes, attributes and children invalidate
+// according to selectors of the sheets
+type invalidationMap struct {
+	// features are invalidations by "#id", ".class", "[name" of attribute
+	// and ":empty", which an element gains or loses
+	features map[string]invalidation
+	// childList is the invalidation for each child of an element, which children change,
+	// because of sibling combinators, structural pseudo-classes and :has()
+	childList invalidation
+}
+
+// positionalPseudoClasses depend on siblings of the element
+var positionalPseudoClasses = map[string]bool{
+	"first-child": true, "last-child": true, "only-child": true,
+	"first-of-type": true, "last-of-type": true, "only-of-type": true,
+	"nth-child": true, "nth-last-child": true, "nth-of-type": true, "nth-last-of-type": true,
+}
+
+func newInvalidationMap(sheets []*Stylesheet) *invalidationMap {
+	m := &invalidationMap{features: make(map[string]invalidation)}
+	for _, sheet := range sheets {
+		for _, rule := range sheet.rules {
+			for _, sel := range rule.selectors {
+				m.addSelector(sel, restyleSelf, 0)
+			}
+		}
+	}
+	return m
+}
+
+// addSelector adds features of the selector, which subject is in the given relation
+// to the changed element. inHas is the relation of :has() anchor if the selector
+// is an argument of :has().
+func (m *invalidationMap) addSelector(sel *Selector, relation, inHas restyleHint) {
+	for s := sel; s != nil; s = s.left {
+		m.addCompound(s, relation, inHas)
+		if s.left == nil {
+			break
+		}
+		// the left compound is matched by an ancestor or a preceding sibling of the right one
+		if s.combinator == adjacentSibling || s.combinator == generalSibling {
+			m.addFeature(&m.childList, relation, inHas)
+			relation |= restyleSiblings
+		} else {
+			relation |= restyleDescendants
+		}
+		if relation != restyleSelf {
+			relation &^= restyleSelf
+		}
+	}
+}
+
+func (m *invalidationMap) addCompound(s *Selector, relation, inHas restyleHint) {
+	keys := []string{}
+	if s.id != nil {
+		keys = append(keys, "#"+*s.id)
+	}
+	for _, class := range s.class {
+		keys = append(keys, "."+class)
+	}
+	for _, attr := range s.attrs {
+		keys = append(keys, "["+attr.name)
+	}
+	for _, pseudo := range s.pseudo {
+		switch {
+		case pseudo.name == "empty":
+			keys = append(keys, ":empty")
+		case pseudo.name == "has":
+			m.addFeature(&m.childList, 0, inHas|relation)
+			for _, arg := range pseudo.selectors {
+				m.addSelector(arg, restyleSelf, inHas|relation)
+			}
+			continue
+		case positionalPseudoClasses[pseudo.name]:
+			m.addFeature(&m.childList, relation, inHas)
+		}
+		for _, arg := range pseudo.selectors {
+			m.addSelector(arg, relation, inHas)
+		}
+	}
+	for _, key := range keys {
+		inv := m.features[key]
+		m.addFeature(&inv, relation, inHas)
+		m.features[key] = inv
+	}
+}
+
+func (m *invalidationMap) addFeature(inv *invalidation, relation, inHas restyleHint) {
+	if inHas != 0 {
+		inv.has |= inHas
+	} else {
+		inv.hint |= relation
+	}
+}
+
+// attributeChange returns what a change of the attribute from old to new value invalidates,
+// the element itself is always restyled, as style, hints and attr() depend on attributes
+func (m *invalidationMap) attributeChange(name, old, new string) invalidation {
+	inv := invalidation{hint: restyleSelf}
+	inv.add(m.features["["+name])
+	switch name {
+	case "id":
+		inv.add(m.features["#"+old])
+		inv.add(m.features["#"+new])
+	case "class":
+		oldClasses, newClasses := strings.Fields(old), strings.Fields(new)
+		for _, class := range symmetricDifference(oldClasses, newClasses) {
+			inv.add(m.features["."+class])
+		}
+	case "cellpadding":
+		// the hint is for cells of the table
+		inv.hint |= restyleDescendants
+	}
+	return inv
+}
+
+func symmetricDifference(a, b []string) []string {
+	count := make(map[string]int)
+	for _, s := range a {
+		count[s] |= 1
+	}
+	for _, s := range b {
+		count[s] |= 2
+	}
+	result := []string{}
+	for s, c := range count {
+		if c != 3 {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// invalidations returns the invalidation map of the document sheets, building it on the first use
+func (doc *StyledDocument) invalidations() *invalidationMap {
+	if doc.features == nil {
+		doc.features = newInvalidationMap(withImports(doc.sheets))
+	}
+	return doc.features
+}
+
+// SetAttribute sets the attribute of the element and marks what it invalidates
+func (doc *StyledDocument) SetAttribute(node *Node, name, value string) {
+	old, ok := node.Attributes[name]
+	if ok && old == value {
+		return
+	}
+	node.Attributes[name] = value
+	doc.invalidate(node, doc.invalidations().attributeChange(name, old, value))
+}
+
+// RemoveAttribute removes the attribute of the element and marks what it invalidates
+func (doc *StyledDocument) RemoveAttribute(node *Node, name string) {
+	old, ok := node.Attributes[name]
+	if !ok {
+		return
+	}
+	delete(node.Attributes, name)
+	doc.invalidate(node, doc.invalidations().attributeChange(name, old, ""))
+}
+
+// InsertBefore inserts the child to the parent before ref, or as the last child if ref is nil.
+// The child is removed from its parent first, if it has one.
+func (doc *StyledDocument) InsertBefore(parent, child, ref *Node) {
+	if child.Parent != nil {
+		doc.RemoveChild(child)
+	}
+	i := len(parent.Children)
+	for j, c := range parent.Children {
+		if c == ref {
+			i = j
+			break
+		}
+	}
+	parent.Children = append(parent.Children, nil)
+	copy(parent.Children[i+1:], parent.Children[i:])
+	parent.Children[i] = child
+	child.Parent = parent
+	// a child moved within the document is styled again
+	doc.mark(child, restyleSelf|restyleDescendants)
+	doc.childrenChanged(parent)
+}
+
+// AppendChild inserts the child as the last one of the parent
+func (doc *StyledDocument) AppendChild(parent, child *Node) {
+	doc.InsertBefore(parent, child, nil)
+}
+
+// RemoveChild removes the node from its parent
+func (doc *StyledDocument) RemoveChild(child *Node) {
+	parent := child.Parent
+	if parent == nil {
+		return
+	}
+	for i, c := range parent.Children {
+		if c == child {
+			parent.Children = append(parent.Children[:i], parent.Children[i+1:]...)
+			break
+		}
+	}
+	child.Parent = nil
+	doc.childrenChanged(parent)
+}
+
+// childrenChanged marks what a change of the parent children invalidates
+func (doc *StyledDocument) childrenChanged(parent *Node) {
+	m := doc.invalidations()
+	inv := invalidation{hint: restyleSelf}
+	inv.add(m.features[":empty"])
+	doc.invalidate(parent, inv)
+	// every child is marked, so siblings of the children are marked too
+	hint := m.childList.hint
+	if hint&restyleSiblings != 0 {
+		hint = hint&^restyleSiblings | restyleSelf
+	}
+	for _, child := range parent.Children {
+		if child.NodeType == ElementNode {
+			doc.mark(child, hint)
+		}
+	}
+	if m.childList.has != 0 {
+		doc.invalidate(parent, invalidation{has: m.childList.has})
+	}
+}
+
+// AddStylesheet adds the sheet after the others and marks elements, which its rules match
+func (doc *StyledDocument) AddStylesheet(sheet *Stylesheet) {
+	doc.sheets = append(doc.sheets, sheet)
+	doc.features = nil
+	doc.invalidateRules(rulesWithImports(sheet))
+}
+
+// RemoveStylesheet removes the sheet and marks elements, which its rules matched
+func (doc *StyledDocument) RemoveStylesheet(sheet *Stylesheet) {
+	for i, s := range doc.sheets {
+		if s == sheet {
+			doc.sheets = append(doc.sheets[:i:i], doc.sheets[i+1:]...)
+			doc.features = nil
+			doc.invalidateRules(rulesWithImports(sheet))
+			return
+		}
+	}
+}
+
+// StylesheetChanged must be called after the sheet is edited in place.
+// rules are the inserted, deleted or edited rules, if none are given
+// the whole document is styled again.
+func (doc *StyledDocument) StylesheetChanged(sheet *Stylesheet, rules ...*Rule) {
+	sheet.resetIndex()
+	doc.features = nil
+	if len(rules) == 0 {
+		doc.mark(doc.root.node, restyleSelf|restyleDescendants)
+		return
+	}
+	doc.invalidateRules(rules)
+}
+
+// invalidateRules marks elements, which selectors of the rules match
+func (doc *StyledDocument) invalidateRules(rules []*Rule) {
+	if len(rules) == 0 {
+		return
+	}
+	index := newRuleIndex(&Stylesheet{rules: rules})
+	ctx := newMatchContext()
+	for node, styled := range doc.styled {
+		if node.NodeType != ElementNode || styled.pseudo != "" {
+			continue
+		}
+		matched := false
+		for pseudo := range index {
+			index.candidates(node, pseudo, nil, func(entry *indexedSelector) {
+				matched = matched || ctx.matches(node, entry.selector)
+			})
+		}
+		if matched {
+			doc.mark(node, restyleSelf)
+		}
+	}
+}
+
+// invalidate marks the node and the nodes related to it by the invalidation
+func (doc *StyledDocument) invalidate(node *Node, inv invalidation) {
+	doc.markRelated(node, inv.hint)
+	if inv.has == 0 {
+		return
+	}
+	for n := node; n != nil; n = n.Parent {
+		doc.markRelated(n, inv.has)
+		if n.Parent == nil {
+			break
+		}
+		for _, sibling := range n.Parent.Children {
+			if sibling == n {
+				break
+			}
+			doc.markRelated(sibling, inv.has)
+		}
+	}
+}
+
+// markRelated marks the node and, for restyleSiblings, its following siblings
+func (doc *StyledDocument) markRelated(node *Node, hint restyleHint) {
+	doc.mark(node, hint&^restyleSiblings)
+	if hint&restyleSiblings == 0 || node.Parent == nil {
+		return
+	}
+	following := false
+	for _, sibling := range node.Parent.Children {
+		if following && sibling.NodeType == ElementNode {
+			doc.mark(sibling, restyleSelf|hint&restyleDescendants)
+		}
+		following = following || sibling == node
+	}
+}
+
+// mark sets dirty bits of the styled node and tells its ancestors
+// that they have dirty descendants. Nodes, which are not styled yet, are skipped,
+// as they are styled when their parent is restyled.
+func (doc *StyledDocument) mark(node *Node, hint restyleHint) {
+	styled, ok := doc.styled[node]
+	if !ok || hint == 0 {
+		return
+	}
+	styled.restyle |= hint
+	for p := node.Parent; p != nil; p = p.Parent {
+		ancestor, ok := doc.styled[p]
+		if !ok || ancestor.dirtyDescendants {
+			break
+		}
+		ancestor.dirtyDescendants = true
+	}
+}
+
+// Restyle brings styles of the marked nodes up to date and drops the layout tree,
+// ComputedStyle, Explain and Layout call it themselves
+func (doc *StyledDocument) Restyle() {
+	if doc.root.restyle == 0 && !doc.root.dirtyDescendants {
+		return
+	}
+	s := newStyler(doc.sheets)
+	s.media = doc.media
+	doc.restyleNode(s, doc.root, nil, 0)
+	generateContent(doc.root)
+	doc.styled = make(map[*Node]*styledNode)
+	doc.indexStyled(doc.root)
+	doc.layout, doc.boxes = nil, nil
+}
+
+// restyleNode updates the styled node and its subtree, parent is the parent computed values,
+// flags are what the parent passes down to its children
+func (doc *StyledDocument) restyleNode(s *styler, styled *styledNode, parent propertyMap, flags restyleHint) {
+	node := styled.node
+	hint := styled.restyle | flags
+	dirtyDescendants := styled.dirtyDescendants
+	styled.restyle, styled.dirtyDescendants = 0, false
+	rematch := hint&restyleSelf != 0
+	childFlags := hint & (restyleDescendants | recomputeDescendants)
+	if childFlags&restyleDescendants != 0 {
+		childFlags |= restyleSelf
+	}
+	topLevel := node.NodeType == ElementNode && node.ParentElement() == nil
+	if rematch || hint&(recomputeSelf|recomputeDescendants) != 0 {
+		specified := styled.specifiedValues
+		if rematch {
+			specified = s.matchRules(node, "")
+		}
+		computed := computeValues(specified, parent, s.rootFontSize)
+		changed := !reflect.DeepEqual(computed, styled.computedValues)
+		if changed {
+			childFlags |= recomputeSelf
+		}
+		if topLevel && computed["font-size"].toPx() != styled.computedValues["font-size"].toPx() {
+			// rem units of the whole subtree have changed
+			childFlags |= recomputeDescendants
+		}
+		styled.specifiedValues, styled.computedValues = specified, computed
+		if rematch || changed && (styled.firstLine != nil || styled.firstLetter != nil) {
+			styled.firstLine = s.matchPseudoRules(node, "first-line", computed)
+			styled.firstLetter = s.matchPseudoRules(node, "first-letter", computed)
+		}
+		s.updateContainer(styled)
+	}
+	if topLevel {
+		s.rootFontSize = styled.computedValues["font-size"].toPx()
+	}
+	if rematch || childFlags != 0 || dirtyDescendants {
+		doc.restyleChildren(s, styled, rematch, childFlags)
+	}
+}
+
+// restyleChildren rebuilds children of the styled node from children of its node,
+// restyling those it has and styling new ones
+func (doc *StyledDocument) restyleChildren(s *styler, styled *styledNode, rematch bool, flags restyleHint) {
+	node, computed := styled.node, styled.computedValues
+	var before, after *styledNode
+	for _, child := range styled.children {
+		switch child.pseudo {
+		case "before":
+			before = child
+		case "after":
+			after = child
+		}
+	}
+	if rematch {
+		before = s.stylePseudoElement(node, "before", computed)
+		after = s.stylePseudoElement(node, "after", computed)
+	} else if flags&(recomputeSelf|recomputeDescendants) != 0 {
+		for _, pseudo := range []*styledNode{before, after} {
+			if pseudo != nil {
+				pseudo.computedValues = computeValues(pseudo.specifiedValues, computed, s.rootFontSize)
+			}
+		}
+	}
+	children := []*styledNode{}
+	if before != nil {
+		children = append(children, before)
+	}
+	if node.NodeType == ElementNode {
+		s.ancestors.push(node)
+	}
+	for _, child := range node.Children {
+		if old, ok := doc.styled[child]; ok {
+			doc.restyleNode(s, old, computed, flags)
+			children = append(children, old)
+		} else {
+			children = append(children, s.styleTree(child, computed))
+		}
+	}
+	if node.NodeType == ElementNode {
+		s.ancestors.pop(node)
+	}
+	if after != nil {
+		children = append(children, after)
+	}
+	styled.children = children
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func compareStyledTrees(t *testing.T, name string, a, b *styledNode) {
+	t.Helper()
+	if a.node.TagName() != b.node.TagName() || !reflect.DeepEqual(a.computedValues, b.computedValues) ||
+		!reflect.DeepEqual(a.firstLine, b.firstLine) || len(a.children) != len(b.children) {
+		t.Fatalf("%s: styles of %s differ: %v and %v", name, a.node, a.computedValues, b.computedValues)
+	}
+	if a.node.NodeType == TextNode && a.node.Data != b.node.Data {
+		t.Fatalf("%s: text %q differs from %q", name, a.node.Data, b.node.Data)
+	}
+	for i := range a.children {
+		compareStyledTrees(t, name, a.children[i], b.children[i])
+	}
+}
+
+func TestRestyle(t *testing.T) {
+	html := `<html style="font-size: 10px"><body><div id="main"><ul><li>1</li><li class="x">2</li><li>3</li></ul>` +
+		`<p>text <a href="#">link</a></p><p class="note"></p><section><span>s</span></section></div></body></html>`
+	css := `.on p {color: #ff0000} .x + li {color: #00ff00} li:last-child {color: #0000ff}
+		#main {font-size: 2em} #other {font-size: 3em} [data-big] a {font-size: 2rem}
+		section:has(.mark) {display: none} p:empty {margin-top: 1px} .note::before {content: "Note: " attr(title)}
+		li::before {counter-increment: n; content: counter(n) ". "} ul:has(> li:nth-child(4)) {color: #000001}`
+	steps := []struct {
+		name   string
+		change func(doc *StyledDocument, find func(string) *Node)
+	}{
+		{"class of ancestor", func(doc *StyledDocument, find func(string) *Node) {
+			doc.SetAttribute(find("div"), "class", "on")
+		}},
+		{"class of sibling", func(doc *StyledDocument, find func(string) *Node) {
+			doc.RemoveAttribute(find("ul").Children[1], "class")
+			doc.SetAttribute(find("ul").Children[0], "class", "x")
+		}},
+		{"id", func(doc *StyledDocument, find func(string) *Node) {
+			doc.SetAttribute(find("div"), "id", "other")
+		}},
+		{"attribute of root", func(doc *StyledDocument, find func(string) *Node) {
+			doc.SetAttribute(find("body"), "data-big", "")
+			doc.SetAttribute(find("html"), "style", "font-size: 12px")
+		}},
+		{"attr()", func(doc *StyledDocument, find func(string) *Node) {
+			doc.SetAttribute(find("ul").Parent.Children[2], "title", "t")
+		}},
+		{"append child", func(doc *StyledDocument, find func(string) *Node) {
+			doc.AppendChild(find("ul"), NewElementNode("li", map[string]string{}, []*Node{NewTextNode("4")}))
+			doc.AppendChild(find("section"), NewElementNode("b", map[string]string{"class": "mark"}, []*Node{}))
+		}},
+		{"remove child", func(doc *StyledDocument, find func(string) *Node) {
+			doc.RemoveChild(find("ul").Children[0])
+			doc.RemoveChild(find("section").Children[1])
+		}},
+		{"move child", func(doc *StyledDocument, find func(string) *Node) {
+			p := find("p")
+			doc.InsertBefore(p.Parent.Children[2], p.Children[1], nil)
+		}},
+		{"class inside :has", func(doc *StyledDocument, find func(string) *Node) {
+			doc.SetAttribute(find("span"), "class", "mark")
+		}},
+	}
+	node, err := parseHTMLWrapped(strings.NewReader(html))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sheet, err := ParseStylesheet(strings.NewReader(css))
+	if err != nil {
+		t.Fatal(err)
+	}
+	find := func(tag string) *Node {
+		var found *Node
+		var visit func(n *Node)
+		visit = func(n *Node) {
+			if found == nil && n.TagName() == tag {
+				found = n
+			}
+			for _, child := range n.Children {
+				visit(child)
+			}
+		}
+		visit(node)
+		return found
+	}
+	doc := NewStyledDocument(node, sheet)
+	for _, step := range steps {
+		step.change(doc, find)
+		doc.Restyle()
+		compareStyledTrees(t, step.name, doc.root, NewStyledDocument(node, sheet).root)
+	}
+
+	extra := mustParseStylesheet(t, `p {color: #000002}`)
+	doc.AddStylesheet(extra)
+	doc.Restyle()
+	compareStyledTrees(t, "add sheet", doc.root, NewStyledDocument(node, sheet, extra).root)
+	rule := mustParseStylesheet(t, `ul li {margin-left: 3px}`).rules[0]
+	sheet.rules = append(sheet.rules, rule)
+	doc.StylesheetChanged(sheet, rule)
+	doc.Restyle()
+	compareStyledTrees(t, "edit sheet", doc.root, NewStyledDocument(node, sheet, extra).root)
+	doc.RemoveStylesheet(extra)
+	doc.Restyle()
+	compareStyledTrees(t, "remove sheet", doc.root, NewStyledDocument(node, sheet).root)
+}
+
+func mustParseStylesheet(t *testing.T, css string) *Stylesheet {
+	t.Helper()
+	sheet, err := ParseStylesheet(strings.NewReader(css))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sheet
+}
+
+func TestRestyleOnlyAffected(t *testing.T) {
+	html := `<div><p>1</p><p>2 <span>s</span></p><p>3</p></div>`
+	css := `.on {color: #ff0000} .on span {margin-top: 1px} .on + p {margin-top: 2px}`
+	node, err := parseHTMLWrapped(strings.NewReader(html))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := NewStyledDocument(node, mustParseStylesheet(t, css))
+	div := node.Children[0]
+	styled := func(n *Node) *styledNode { return doc.styled[n] }
+	before := map[*Node]*styledNode{}
+	specified := map[*Node]propertyMap{}
+	for _, n := range []*Node{div, div.Children[0], div.Children[1], div.Children[2], div.Children[1].Children[1]} {
+		before[n], specified[n] = styled(n), styled(n).specifiedValues
+	}
+	doc.SetAttribute(div.Children[1], "class", "on")
+	if !doc.root.dirtyDescendants || styled(div.Children[0]).restyle != 0 {
+		t.Error("wrong dirty bits")
+	}
+	if styled(div.Children[1]).restyle != restyleSelf|restyleDescendants || styled(div.Children[2]).restyle&restyleSelf == 0 {
+		t.Errorf("wrong restyle hints %b, %b", styled(div.Children[1]).restyle, styled(div.Children[2]).restyle)
+	}
+	doc.Restyle()
+	for n, old := range before {
+		if styled(n) != old {
+			t.Errorf("styled node of %s is replaced", n)
+		}
+	}
+	for _, n := range []*Node{div, div.Children[0]} {
+		if reflect.ValueOf(styled(n).specifiedValues).Pointer() != reflect.ValueOf(specified[n]).Pointer() {
+			t.Errorf("%s is matched again", n)
+		}
+	}
+	span := styled(div.Children[1].Children[1])
+	if span.computedValues["color"].color.R != 255 || span.computedValues["margin-top"].length != 1 {
+		t.Errorf("descendant is not restyled: %v", span.computedValues)
+	}
+	if styled(div.Children[2]).computedValues["margin-top"].length != 2 {
+		t.Error("sibling is not restyled")
+	}
+	if doc.root.dirtyDescendants || styled(div.Children[1]).restyle != 0 {
+		t.Error("dirty bits are not cleared")
+	}
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Serialization of parsed CSS back to text, https://www.w3.org/TR/cssom-1/#serializing-css-values
+// Minified text has no optional spaces, short colors and zero lengths without units.
+
+// unitNames are names of units by their types
+var unitNames = func() map[UnitType]string {
+	names := make(map[UnitType]string, len(units))
+	for name, unit := range units {
+		names[unit] = name
+	}
+	return names
+}()
+
+// String serializes value as specified, lengths keep their units
+func (v Value) String() string {
+	return v.format(false)
+}
+
+func (v Value) format(minify bool) string {
+	switch v.valueType {
+	case Keyword:
+		return v.keyword
+	case Length:
+		if minify && v.length == 0 && v.unitType != Percent {
+			return "0"
+		}
+		return formatNumberMinified(v.length, minify) + unitNames[v.unitType]
+	case Number:
+		return formatNumberMinified(v.length, minify)
+	case ColorValue:
+		if v.color.A == 0 {
+			return "transparent"
+		}
+		s := fmt.Sprintf("#%02x%02x%02x", v.color.R, v.color.G, v.color.B)
+		if minify && s[1] == s[2] && s[3] == s[4] && s[5] == s[6] {
+			s = "#" + s[1:2] + s[3:4] + s[5:6]
+		}
+		return s
+	case StringValue:
+		return quoteString(v.text)
+	case Tokens:
+		return v.text
+	case Pending:
+		return v.args[0].format(minify)
+	case FunctionValue:
+		args := []string{}
+		for _, arg := range v.args {
+			args = append(args, arg.format(minify))
+		}
+		return v.function + "(" + strings.Join(args, separator(",", minify)) + ")"
+	case ListValue:
+		items := []string{}
+		for _, item := range v.list {
+			items = append(items, item.format(minify))
+		}
+		if v.commas {
+			return strings.Join(items, separator(",", minify))
+		}
+		return strings.Join(items, " ")
+	}
+	return ""
+}
+
+// formatNumberMinified drops the leading zero of fractions if minify is set
+func formatNumberMinified(n float32, minify bool) string {
+	s := formatNumber(n)
+	if minify {
+		if strings.HasPrefix(s, "0.") {
+			s = s[1:]
+		} else if strings.HasPrefix(s, "-0.") {
+			s = "-" + s[2:]
+		}
+	}
+	return s
+}
+
+// separator returns the punctuation followed by a space, or alone if minify is set
+func separator(punctuation string, minify bool) string {
+	if minify {
+		return punctuation
+	}
+	return punctuation + " "
+}
+
+// quoteString serializes CSS string in double quotes
+func quoteString(s string) string {
+	b := new(strings.Builder)
+	b.WriteByte('"')
+	for _, c := range s {
+		switch c {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteRune(c)
+		case '\n':
+			b.WriteString(`\a `)
+		default:
+			b.WriteRune(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+var combinatorText = map[combinator]string{
+	descendant:      " ",
+	child:           " > ",
+	adjacentSibling: " + ",
+	generalSibling:  " ~ ",
+}
+
+// combinatorString returns the combinator without spaces around if minify is set
+func combinatorString(c combinator, minify bool) string {
+	if minify && c != descendant {
+		return strings.TrimSpace(combinatorText[c])
+	}
+	return combinatorText[c]
+}
+
+// String serializes complex selector, like "nav > a.active:hover"
+func (s *Selector) String() string {
+	return s.format(false)
+}
+
+func (s *Selector) format(minify bool) string {
+	text := s.compoundString(minify)
+	if s.left != nil {
+		return s.left.format(minify) + combinatorString(s.combinator, minify) + text
+	}
+	return text
+}
+
+// relativeString serializes selector of :has(), with combinator of the leftmost compound
+func (s *Selector) relativeString(minify bool) string {
+	leftmost := s
+	for leftmost.left != nil {
+		leftmost = leftmost.left
+	}
+	if leftmost.combinator == descendant {
+		return s.format(minify)
+	}
+	return strings.TrimLeft(combinatorString(leftmost.combinator, minify), " ") + s.format(minify)
+}
+
+func (s *Selector) compoundString(minify bool) string {
+	b := new(strings.Builder)
+	if s.tagName != nil {
+		b.WriteString(*s.tagName)
+	}
+	if s.id != nil {
+		b.WriteString("#" + *s.id)
+	}
+	for _, class := range s.class {
+		b.WriteString("." + class)
+	}
+	for _, attr := range s.attrs {
+		b.WriteString("[" + attr.name)
+		if attr.op != "" {
+			b.WriteString(attr.op + quoteString(attr.value))
+			if attr.caseInsensitive {
+				b.WriteString(" i")
+			} else if attr.caseSensitive {
+				b.WriteString(" s")
+			}
+		}
+		b.WriteString("]")
+	}
+	for _, pseudo := range s.pseudo {
+		b.WriteString(":" + pseudo.format(minify))
+	}
+	if s.pseudoElement != "" {
+		b.WriteString("::" + s.pseudoElement)
+	}
+	if b.Len() == 0 {
+		return "*"
+	}
+	return b.String()
+}
+
+// String serializes pseudo-class without the leading colon
+func (p pseudoClass) String() string {
+	return p.format(false)
+}
+
+func (p pseudoClass) format(minify bool) string {
+	switch {
+	case structuralPseudoClasses[p.name]:
+		return p.name
+	case p.name == "has":
+		return p.name + "(" + joinSelectors(p.selectors, (*Selector).relativeString, minify) + ")"
+	case p.name == "not" || p.name == "is" || p.name == "where":
+		return p.name + "(" + joinSelectors(p.selectors, (*Selector).format, minify) + ")"
+	}
+	arg := formatAnPlusB(p.a, p.b)
+	if len(p.selectors) > 0 {
+		arg += " of " + joinSelectors(p.selectors, (*Selector).format, minify)
+	}
+	return p.name + "(" + arg + ")"
+}
+
+func joinSelectors(selectors []*Selector, format func(*Selector, bool) string, minify bool) string {
+	parts := []string{}
+	for _, sel := range selectors {
+		parts = append(parts, format(sel, minify))
+	}
+	return strings.Join(parts, separator(",", minify))
+}
+
+// formatAnPlusB serializes an+b, https://www.w3.org/TR/css-syntax-3/#serializing-anb
+func formatAnPlusB(a, b int) string {
+	if a == 0 {
+		return strconv.Itoa(b)
+	}
+	s := ""
+	switch a {
+	case 1:
+		s = "n"
+	case -1:
+		s = "-n"
+	default:
+		s = strconv.Itoa(a) + "n"
+	}
+	if b > 0 {
+		s += "+" + strconv.Itoa(b)
+	} else if b < 0 {
+		s += strconv.Itoa(b)
+	}
+	return s
+}
+
+// String serializes declaration, like "margin: 0px auto !important"
+func (d *Declarator) String() string {
+	return d.format(false)
+}
+
+func (d *Declarator) format(minify bool) string {
+	s := d.name + separator(":", minify) + d.value.format(minify)
+	if d.important {
+		if !minify {
+			s += " "
+		}
+		s += "!important"
+	}
+	return s
+}
+
+// String serializes rule like cssText of CSSOM, "h1, h2 { margin: 0px; color: #cc0000; }"
+func (r *Rule) String() string {
+	return r.SelectorText() + " " + formatBlock(r.declarators, false)
+}
+
+// String serializes rules of the sheet, one per line, after @import and @font-face rules,
+// rules inside @media and @container blocks are indented with two spaces
+func (s *Stylesheet) String() string {
+	b := new(strings.Builder)
+	for _, imp := range s.imports {
+		b.WriteString(imp.String() + "\n")
+	}
+	for _, face := range s.fontFaces {
+		b.WriteString(face.String() + "\n")
+	}
+	visitConditionBlocks(s.rules, false, func(prelude string, depth int) {
+		b.WriteString(strings.Repeat("  ", depth) + prelude + " {\n")
+	}, func(rule *Rule, depth int) {
+		b.WriteString(strings.Repeat("  ", depth) + rule.String() + "\n")
+	}, func(depth int) {
+		b.WriteString(strings.Repeat("  ", depth) + "}\n")
+	})
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// Pretty serializes the sheet with a declaration per line, indented with two spaces,
+// rules are separated by empty lines
+func (s *Stylesheet) Pretty() string {
+	b := new(strings.Builder)
+	first := true
+	separate := func() {
+		if !first {
+			b.WriteString("\n")
+		}
+	}
+	for _, imp := range s.imports {
+		b.WriteString(imp.String() + "\n")
+		first = false
+	}
+	for _, face := range s.fontFaces {
+		separate()
+		b.WriteString("@font-face {\n")
+		for _, d := range face.declarators {
+			b.WriteString("  " + d.String() + ";\n")
+		}
+		b.WriteString("}\n")
+		first = false
+	}
+	visitConditionBlocks(s.rules, false, func(prelude string, depth int) {
+		separate()
+		b.WriteString(strings.Repeat("  ", depth) + prelude + " {\n")
+		first = true
+	}, func(rule *Rule, depth int) {
+		separate()
+		indent := strings.Repeat("  ", depth)
+		b.WriteString(indent + rule.SelectorText() + " {\n")
+		for _, d := range rule.declarators {
+			b.WriteString(indent + "  " + d.String() + ";\n")
+		}
+		b.WriteString(indent + "}\n")
+		first = false
+	}, func(depth int) {
+		b.WriteString(strings.Repeat("  ", depth) + "}\n")
+		first = false
+	})
+	return b.String()
+}
+
+// Minified serializes the sheet in short form, which gives the same cascade:
+// rules with the same selectors and conditions are merged, overridden declarations
+// and empty rules are dropped
+func (s *Stylesheet) Minified() string {
+	b := new(strings.Builder)
+	for _, imp := range s.imports {
+		b.WriteString(imp.format(true))
+	}
+	for _, face := range s.fontFaces {
+		b.WriteString("@font-face" + formatBlock(face.declarators, true))
+	}
+	visitConditionBlocks(mergeRules(s.rules), true, func(prelude string, depth int) {
+		b.WriteString(prelude + "{")
+	}, func(rule *Rule, depth int) {
+		b.WriteString(joinSelectors(rule.selectors, (*Selector).format, true) + formatBlock(rule.declarators, true))
+	}, func(depth int) {
+		b.WriteString("}")
+	})
+	return b.String()
+}
+
+// visitConditionBlocks visits rules in order, opening and closing @media and @container blocks
+// around them, so consecutive rules of the same conditions share blocks. depth is the count of open blocks.
+// @container blocks go inside @media ones, which gives the same conditions.
+func visitConditionBlocks(rules []*Rule, minify bool, open func(prelude string, depth int), visit func(rule *Rule, depth int), close func(depth int)) {
+	opened := []string{}
+	closeTo := func(depth int) {
+		for len(opened) > depth {
+			opened = opened[:len(opened)-1]
+			close(len(opened))
+		}
+	}
+	for _, rule := range rules {
+		preludes := conditionText(rule, minify)
+		common := 0
+		for common < len(opened) && common < len(preludes) && opened[common] == preludes[common] {
+			common++
+		}
+		closeTo(common)
+		for _, prelude := range preludes[common:] {
+			open(prelude, len(opened))
+			opened = append(opened, prelude)
+		}
+		visit(rule, len(opened))
+	}
+	closeTo(0)
+}
+
+// conditionText returns serialized preludes of @media and @container rules around the rule
+func conditionText(rule *Rule, minify bool) []string {
+	preludes := []string{}
+	for _, list := range rule.media {
+		preludes = append(preludes, "@media "+list.format(minify))
+	}
+	for _, q := range rule.containers {
+		preludes = append(preludes, "@container "+q.format(minify))
+	}
+	return preludes
+}
+
+// mergeRules merges each rule into the next one with the same selectors and conditions,
+// if no rule between them declares properties of the moved declarations,
+// so the order of competing declarations stays the same.
+// Overridden declarations and empty rules are dropped.
+func mergeRules(rules []*Rule) []*Rule {
+	merged := make([]*Rule, len(rules))
+	for i, rule := range rules {
+		merged[i] = &Rule{selectors: rule.selectors, declarators: rule.declarators, line: rule.line, conditions: rule.conditions}
+	}
+	key := func(rule *Rule) string {
+		return strings.Join(conditionText(rule, true), "\x00") + "\x00" + joinSelectors(rule.selectors, (*Selector).format, true)
+	}
+	for i, rule := range merged {
+		selectors := key(rule)
+		for _, next := range merged[i+1:] {
+			if key(next) == selectors {
+				next.declarators = append(append([]*Declarator{}, rule.declarators...), next.declarators...)
+				rule.declarators = nil
+				break
+			}
+			if declaresAny(next, rule.declarators) {
+				break
+			}
+		}
+	}
+	result := []*Rule{}
+	for _, rule := range merged {
+		if len(rule.declarators) > 0 {
+			rule.declarators = dropOverridden(rule.declarators)
+			result = append(result, rule)
+		}
+	}
+	return result
+}
+
+// declaresAny tells if the rule declares some of the properties of declarators,
+// or their shorthands or longhands
+func declaresAny(rule *Rule, declarators []*Declarator) bool {
+	for _, d := range rule.declarators {
+		for _, other := range declarators {
+			if d.name == other.name || strings.HasPrefix(d.name, other.name+"-") || strings.HasPrefix(other.name, d.name+"-") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// dropOverridden keeps the last declaration of each property,
+// or the last important one if there is one
+func dropOverridden(declarators []*Declarator) []*Declarator {
+	winner := make(map[string]int)
+	for i, d := range declarators {
+		if w, ok := winner[d.name]; !ok || d.important || !declarators[w].important {
+			winner[d.name] = i
+		}
+	}
+	result := []*Declarator{}
+	for i, d := range declarators {
+		if winner[d.name] == i {
+			result = append(result, d)
+		}
+	}
+	return result
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSelectorString(t *testing.T) {
+	tests := []string{
+		"*",
+		"div > p#main.note",
+		"ul li + li ~ li",
+		`a[href^="http" i][title]`,
+		"li:nth-child(2n+1 of .x):first-child",
+		"p:not(.a, .b)::before",
+		"section:has(> h1, img)",
+	}
+	for _, tt := range tests {
+		sel, err := parseSelector(bufio.NewReader(strings.NewReader(tt)))
+		if err != nil {
+			t.Fatal(tt, err)
+		}
+		if got := sel.String(); got != tt {
+			t.Errorf("got %q, want %q", got, tt)
+		}
+	}
+}
+
+func TestValueString(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"1.5em", "1.5em"},
+		{"50%", "50%"},
+		{"#FF8000", "#ff8000"},
+		{`"a\"b"`, `"a\"b"`},
+		{"counter(item, upper-roman) \". \"", `counter(item, upper-roman) ". "`},
+		{"Georgia, serif", "Georgia, serif"},
+		{"0", "0"},
+	}
+	for _, tt := range tests {
+		v, err := parseValue(mr(tt.in))
+		if err != nil {
+			t.Fatal(tt.in, err)
+		}
+		if got := v.String(); got != tt.want {
+			t.Errorf("String() of %s = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func stripLines(sheet *Stylesheet) []*Rule {
+	for _, rule := range sheet.rules {
+		rule.line = 0
+	}
+	return sheet.rules
+}
+
+func TestStylesheetRoundTrip(t *testing.T) {
+	css := `@import url(base.css) screen and (min-width: 600px); @import "print.css";
+		h1, h2 {margin: 0 auto; color: #cc0000}
+		@font-face {font-family: "Brand"; src: url(brand.ttf) format("truetype"); font-weight: 700}
+		div.note > p + a[href$=".pdf" i]:not(.x, .y)::before {content: "\"" counter(n, upper-roman); padding: 0.5em !important}
+		section:has(> h1) li:nth-child(-n+3 of .item) {font-family: Georgia, "Times New Roman", serif; width: 50%}
+		@media screen and (min-width: 600px), print { p {margin: 0} @media not print { a {color: #ff0000} } em {margin: 1px} }
+		@container card (400px <= width < 800px) { p {margin: 2px} @media print { i {margin: 0} } }
+		b {margin: 0; --Gap: calc(1px + 2px) "a;b"; margin-top: var(--gap, 0 1px)}`
+	sheet := mustParseStylesheet(t, css)
+	for _, format := range []func(*Stylesheet) string{(*Stylesheet).Pretty, (*Stylesheet).Minified, (*Stylesheet).String} {
+		text := format(sheet)
+		parsed, err := ParseStylesheet(strings.NewReader(text))
+		if err != nil {
+			t.Fatalf("%s: %v", text, err)
+		}
+		if !reflect.DeepEqual(stripLines(parsed), stripLines(sheet)) || !reflect.DeepEqual(parsed.imports, sheet.imports) ||
+			!reflect.DeepEqual(parsed.fontFaces, sheet.fontFaces) {
+			t.Errorf("%s is parsed to other rules", text)
+		}
+		if again := format(parsed); again != text {
+			t.Errorf("got %q after round trip, want %q", again, text)
+		}
+	}
+}
+
+func TestUnitlessZeroRoundTrip(t *testing.T) {
+	css := "a { opacity: 0; z-index: 0; margin: 0 auto; }"
+	if got := mustParseStylesheet(t, css).String(); got != css {
+		t.Errorf("got %q, want %q", got, css)
+	}
+}
+
+func TestStylesheetPretty(t *testing.T) {
+	sheet := mustParseStylesheet(t, `h1,h2{margin:0}p{color:#CC0000 !important;font-size:1.5em}`)
+	want := "h1, h2 {\n  margin: 0;\n}\n\np {\n  color: #cc0000 !important;\n  font-size: 1.5em;\n}\n"
+	if got := sheet.Pretty(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestStylesheetMinified(t *testing.T) {
+	tests := []struct{ css, want string }{
+		{`p { margin: 0px 0.5em; color: #aabbcc; background-color: #aabbcd }`, `p{margin:0 .5em;color:#abc;background-color:#aabbcd}`},
+		{`ul > li + li, a ~ b {width: 0%}`, `ul>li+li,a~b{width:0%}`},
+		{`p {color: #000000; color: #ffffff}`, `p{color:#fff}`},
+		{`p {color: #000000 !important; color: #ffffff}`, `p{color:#000!important}`},
+		{`p {color: #000000} a {margin: 0} p {padding: 0}`, `a{margin:0}p{color:#000;padding:0}`},
+		{`p {color: #000000} a {color: #ffffff} p {color: #ff0000}`, `p{color:#000}a{color:#fff}p{color:#f00}`},
+		{`p {margin-top: 1px} a {margin: 0} p {color: #ff0000}`, `p{margin-top:1px}a{margin:0}p{color:#f00}`},
+		{`p {} a {font-family: A, B}`, `a{font-family:A,B}`},
+	}
+	for _, tt := range tests {
+		if got := mustParseStylesheet(t, tt.css).Minified(); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.css, got, tt.want)
+		}
+	}
+}
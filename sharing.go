@@ -0,0 +1,141 @@
+package main
+
+import "strings"
+
+// Style sharing between siblings, which match the same rules, like rows of a table.
+// Siblings have the same parent style and ancestors, so the style is shared if
+// the element has the same tag, id, classes and attributes of hints and style,
+// and the same results of revalidation selectors: those which depend on
+// attributes, pseudo-classes or siblings.
+// Children of siblings, which share style, are like siblings for the rest of selectors,
+// as their parents differ only in what revalidation selectors check.
+
+// maxSharingCandidates is how many recent siblings are checked
+const maxSharingCandidates = 8
+
+// sharedStyle is a styled sibling and what its style depends on,
+// children is the sharing cache of its children.
+// revalidation is nil until it is needed.
+type sharedStyle struct {
+	key          string
+	revalidation []*Selector
+	styled       *styledNode
+	children     sharingCache
+}
+
+// sharingCache keeps recent siblings, the newest last
+type sharingCache []sharedStyle
+
+// hintAttributes are attributes which give presentational hints or style
+var hintAttributes = []string{
+	"style", "hidden", "bgcolor", "width", "height", "align", "valign", "border",
+	"cellpadding", "color", "size", "face",
+}
+
+// sharingKey joins what must be equal for the siblings to share style,
+// ok is false if the element can't share style at all
+func sharingKey(node *Node) (string, bool) {
+	if node.NodeType == TextNode {
+		return "#text", true
+	} else if node.NodeType != ElementNode || node.ParentElement() == nil {
+		return "", false
+	}
+	b := new(strings.Builder)
+	b.WriteString(node.TagName())
+	for _, name := range []string{"id", "class"} {
+		v, ok := node.Attributes[name]
+		b.WriteString("\x00")
+		if ok {
+			b.WriteString("=" + v)
+		}
+	}
+	for _, name := range hintAttributes {
+		if v, ok := node.Attributes[name]; ok {
+			b.WriteString("\x00" + name + "=" + v)
+		}
+	}
+	return b.String(), true
+}
+
+// lookupShared returns a sibling from the cache, which style the node can share
+func (s *styler) lookupShared(c sharingCache, node *Node, key string) *sharedStyle {
+	var matched []*Selector
+	for i := len(c) - 1; i >= 0; i-- {
+		if c[i].key != key {
+			continue
+		}
+		if matched == nil {
+			matched = s.revalidate(node, s.ancestors)
+		}
+		if c[i].revalidation == nil {
+			// the sibling may be a cousin, with other ancestors in the filter
+			c[i].revalidation = s.revalidate(c[i].styled.node, nil)
+		}
+		if sameSelectors(c[i].revalidation, matched) {
+			return &c[i]
+		}
+	}
+	return nil
+}
+
+func (c *sharingCache) add(entry sharedStyle) {
+	if len(*c) == maxSharingCandidates {
+		*c = append((*c)[:0], (*c)[1:]...)
+	}
+	*c = append(*c, entry)
+}
+
+func sameSelectors(a, b []*Selector) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// revalidationIndex indexes selectors of the sheets which need revalidation
+func revalidationIndex(sheets []*Stylesheet) ruleIndex {
+	sheet := &Stylesheet{}
+	for _, s := range sheets {
+		for _, rule := range s.rules {
+			for _, sel := range rule.selectors {
+				if needsRevalidation(sel) {
+					sheet.rules = append(sheet.rules, &Rule{selectors: []*Selector{sel}})
+				}
+			}
+		}
+	}
+	return newRuleIndex(sheet)
+}
+
+// needsRevalidation tells if the selector may match only some of siblings
+// with the same tag, id and classes
+func needsRevalidation(sel *Selector) bool {
+	for s := sel; s != nil; s = s.left {
+		if len(s.attrs) > 0 || len(s.pseudo) > 0 || s.combinator == adjacentSibling || s.combinator == generalSibling {
+			return true
+		}
+	}
+	return false
+}
+
+// revalidate returns revalidation selectors which match the element,
+// in the order of the index. filter must have ancestors of the node, or be nil.
+func (s *styler) revalidate(node *Node, filter *ancestorFilter) []*Selector {
+	matched := []*Selector{}
+	if node.NodeType != ElementNode {
+		return matched
+	}
+	for _, pseudo := range []string{"", "before", "after", "first-line", "first-letter"} {
+		s.revalidation.candidates(node, pseudo, filter, func(entry *indexedSelector) {
+			if s.ctx.matches(node, entry.selector) {
+				matched = append(matched, entry.selector)
+			}
+		})
+	}
+	return matched
+}
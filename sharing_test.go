@@ -0,0 +1,81 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStyleSharing(t *testing.T) {
+	html := `<ul><li class="a">1</li><li class="a">2</li><li class="b">3</li><li class="a" title="x">4</li><li class="a">5</li><li class="a" style="color: #000001">6</li></ul>`
+	css := `li {color: #ff0000} .b {color: #00ff00} li:nth-child(5) {display: block} [title] {display: none}`
+	ul := styleString(t, html, css).children[0]
+	shared := func(i, j int) bool {
+		return reflect.ValueOf(ul.children[i].computedValues).Pointer() == reflect.ValueOf(ul.children[j].computedValues).Pointer()
+	}
+	if !shared(0, 1) {
+		t.Error("identical siblings should share style")
+	}
+	if shared(1, 2) || shared(1, 3) || shared(1, 4) || shared(1, 5) {
+		t.Error("siblings with different classes, attributes, positions or style should not share")
+	}
+	if !ul.children[4].computedValues["display"].isKeyword("block") || !ul.children[3].computedValues["display"].isKeyword("none") {
+		t.Error("wrong revalidated values")
+	}
+	if ul.children[5].computedValues["color"].color.B != 1 {
+		t.Error("style attribute should apply")
+	}
+}
+
+func TestStyleSharingGivesSameStyles(t *testing.T) {
+	html, css := generatedPage(500)
+	css += `li:first-child a {color: #000001} li + li {margin-top: 1px} a[title="t"]::after {content: "!"}`
+	node, err := parseHTMLWrapped(strings.NewReader(html))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sheet, err := ParseStylesheet(strings.NewReader(css))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sharing := newStyler([]*Stylesheet{sheet})
+	plain := newStyler([]*Stylesheet{sheet})
+	plain.shareStyles = false
+	var compare func(a, b *styledNode)
+	compare = func(a, b *styledNode) {
+		if !reflect.DeepEqual(a.computedValues, b.computedValues) || len(a.children) != len(b.children) {
+			t.Fatalf("styles of %s differ: %v and %v", a.node.TagName(), a.computedValues, b.computedValues)
+		}
+		for i := range a.children {
+			compare(a.children[i], b.children[i])
+		}
+	}
+	compare(sharing.styleTree(node, nil), plain.styleTree(node, nil))
+}
+
+func BenchmarkStyleSharing(b *testing.B) {
+	html := new(strings.Builder)
+	html.WriteString("<table>")
+	for i := 0; i < 2000; i++ {
+		html.WriteString(`<tr class="row"><td class="cell">a</td><td class="cell">b</td><td class="cell num">1</td></tr>`)
+	}
+	html.WriteString("</table>")
+	node, err := parseHTMLWrapped(strings.NewReader(html.String()))
+	if err != nil {
+		b.Fatal(err)
+	}
+	sheets := []*Stylesheet{UserAgentStylesheet()}
+	for _, shareStyles := range []bool{true, false} {
+		name := "shared"
+		if !shareStyles {
+			name = "unshared"
+		}
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				s := newStyler(sheets)
+				s.shareStyles = shareStyles
+				s.styleTree(node, nil)
+			}
+		})
+	}
+}
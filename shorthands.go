@@ -0,0 +1,165 @@
+package main
+
+// Shorthand properties, https://www.w3.org/TR/css-cascade-4/#shorthand
+// The cascade expands margin, padding and border shorthands to their longhands,
+// so that a shorthand overrides longhands declared before it and the other way round,
+// and layout reads only longhands. Longhands of a shorthand with var() are pending
+// until var() is substituted, https://www.w3.org/TR/css-variables-1/#variables-in-shorthands
+
+var boxSides = []string{"top", "right", "bottom", "left"}
+
+// shorthands are longhands of shorthand properties, border ones go side by side
+var shorthands = func() map[string][]string {
+	m := make(map[string][]string)
+	for _, side := range boxSides {
+		m["margin"] = append(m["margin"], "margin-"+side)
+		m["padding"] = append(m["padding"], "padding-"+side)
+		for _, part := range []string{"width", "style", "color"} {
+			longhand := "border-" + side + "-" + part
+			m["border-"+part] = append(m["border-"+part], longhand)
+			m["border-"+side] = append(m["border-"+side], longhand)
+			m["border"] = append(m["border"], longhand)
+		}
+	}
+	return m
+}()
+
+var borderStyles = map[string]bool{
+	"none": true, "hidden": true, "dotted": true, "dashed": true, "solid": true,
+	"double": true, "groove": true, "ridge": true, "inset": true, "outset": true,
+}
+
+var borderWidths = map[string]bool{"thin": true, "medium": true, "thick": true}
+
+// expandShorthands returns matched declarations with each shorthand followed
+// by declarations of its longhands, which take part in the cascade with its precedence
+func expandShorthands(matched []matchedDeclaration) []matchedDeclaration {
+	count := 0
+	for i := range matched {
+		count += len(shorthands[matched[i].decl.name])
+	}
+	if count == 0 {
+		return matched
+	}
+	result := make([]matchedDeclaration, 0, len(matched)+count)
+	for _, m := range matched {
+		result = append(result, m)
+		longhands := shorthands[m.decl.name]
+		if len(longhands) == 0 {
+			continue
+		}
+		values, ok := longhandValues(m.decl.name, m.decl.value)
+		if !ok {
+			continue
+		}
+		for i, name := range longhands {
+			longhand := m
+			longhand.decl = &Declarator{name: name, value: values[i], important: m.decl.important}
+			result = append(result, longhand)
+		}
+	}
+	return result
+}
+
+// longhandValues returns values of longhands of the shorthand in the order of shorthands,
+// false if the value is invalid for the shorthand
+func longhandValues(shorthand string, v Value) ([]Value, bool) {
+	longhands := shorthands[shorthand]
+	values := make([]Value, len(longhands))
+	switch {
+	case containsVar(v):
+		for i := range values {
+			values[i] = Value{valueType: Pending, function: shorthand, args: []Value{v}}
+		}
+	case v.isKeyword("inherit") || v.isKeyword("initial") || v.isKeyword("unset") || v.isKeyword("revert"):
+		for i := range values {
+			values[i] = v
+		}
+	case shorthand == "border":
+		parts, ok := borderParts(v)
+		if !ok {
+			return nil, false
+		}
+		for i := range values {
+			values[i] = parts[i%3]
+		}
+	case len(longhands) == 3:
+		parts, ok := borderParts(v)
+		if !ok {
+			return nil, false
+		}
+		copy(values, parts[:])
+	default:
+		sides, ok := sideValues(v)
+		if !ok {
+			return nil, false
+		}
+		copy(values, sides[:])
+	}
+	return values, true
+}
+
+// sideValues returns top, right, bottom and left of 1 to 4 values, like margin: 0 auto
+func sideValues(v Value) ([4]Value, bool) {
+	items := []Value{v}
+	if v.valueType == ListValue {
+		items = v.list
+	}
+	if v.commas || len(items) > 4 {
+		return [4]Value{}, false
+	}
+	switch len(items) {
+	case 1:
+		return [4]Value{items[0], items[0], items[0], items[0]}, true
+	case 2:
+		return [4]Value{items[0], items[1], items[0], items[1]}, true
+	case 3:
+		return [4]Value{items[0], items[1], items[2], items[1]}, true
+	}
+	return [4]Value{items[0], items[1], items[2], items[3]}, true
+}
+
+// borderParts returns width, style and color of border in any order,
+// missing ones are initial
+func borderParts(v Value) ([3]Value, bool) {
+	parts := [3]Value{keywordValue("medium"), keywordValue("none"), keywordValue("currentcolor")}
+	items := []Value{v}
+	if v.valueType == ListValue {
+		items = v.list
+	}
+	if v.commas || len(items) > 3 {
+		return parts, false
+	}
+	seen := [3]bool{}
+	for _, item := range items {
+		i := 2
+		switch {
+		case item.valueType == Length || item.valueType == Number || item.valueType == Keyword && borderWidths[item.keyword]:
+			i = 0
+		case item.valueType == Keyword && borderStyles[item.keyword]:
+			i = 1
+		case item.valueType != Keyword && item.valueType != ColorValue:
+			return parts, false
+		}
+		if seen[i] {
+			return parts, false
+		}
+		parts[i], seen[i] = item, true
+	}
+	return parts, true
+}
+
+// pendingValue returns the value of the longhand from the value of its shorthand,
+// which has var() substituted
+func pendingValue(shorthand, longhand string, v Value) (Value, bool) {
+	values, ok := longhandValues(shorthand, v)
+	if !ok {
+		return Value{}, false
+	}
+	for i, name := range shorthands[shorthand] {
+		if name == longhand {
+			return values[i], true
+		}
+	}
+	return Value{}, false
+}
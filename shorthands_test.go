@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestShorthandsOverrideUserAgent(t *testing.T) {
+	node, err := parseHTMLWrapped(strings.NewReader(`<html><body><p style="margin: 4px">a</p><p>b</p></body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := NewStyledDocument(node, UserAgentStylesheet(), mustParseStylesheet(t, `body {margin: 0} p {margin: 0 2px}`))
+	doc.Layout(800, 600)
+	body := node.Children[0].Children[0]
+	p1, p2 := body.Children[0], body.Children[1]
+	tests := []struct {
+		node       *Node
+		name, want string
+	}{
+		{body, "margin-top", "0px"},
+		{body, "margin-left", "0px"},
+		{p1, "margin-top", "4px"},
+		{p1, "margin-left", "4px"},
+		{p2, "margin-top", "0px"},
+		{p2, "margin-left", "2px"},
+	}
+	for _, tt := range tests {
+		if got := doc.ComputedStyle(tt.node)[tt.name]; got != tt.want {
+			t.Errorf("%s of %s = %q, want %q", tt.name, tt.node.TagName(), got, tt.want)
+		}
+	}
+	if x := doc.boxes[body].dimensions.content.x; x != 0 {
+		t.Errorf("body is laid out at %v", x)
+	}
+}
+
+func TestShorthandExpansion(t *testing.T) {
+	node, err := parseHTMLWrapped(strings.NewReader(`<div><p id="a">a</p><p id="b">b</p><p id="c">c</p><p id="d">d</p></div>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := NewStyledDocument(node, mustParseStylesheet(t, `div {--m: 3px 6px}
+		#a {margin: 1px; margin-left: 5px; padding: 1px 2px 3px}
+		#b {margin-left: 5px; margin: 1px; border: solid 2px; border-left: 0 #ff0000}
+		#c {margin: var(--m); padding: var(--missing)}
+		#d {margin: 1px 2px 3px 4px 5px; border-top: 1px 2px}`))
+	a, b, c, d := node.Children[0].Children[0], node.Children[0].Children[1], node.Children[0].Children[2], node.Children[0].Children[3]
+	tests := []struct {
+		node       *Node
+		name, want string
+	}{
+		{a, "margin-top", "1px"},
+		{a, "margin-left", "5px"},
+		{a, "padding-right", "2px"},
+		{a, "padding-bottom", "3px"},
+		{a, "padding-left", "2px"},
+		{b, "margin-left", "1px"},
+		{b, "border-top-width", "2px"},
+		{b, "border-top-style", "solid"},
+		{b, "border-top-color", "rgb(0, 0, 0)"},
+		{b, "border-left-width", "0px"},
+		{b, "border-left-style", "none"},
+		{b, "border-left-color", "rgb(255, 0, 0)"},
+		{c, "margin-top", "3px"},
+		{c, "margin-right", "6px"},
+		{c, "margin-bottom", "3px"},
+		{c, "padding-top", ""},
+		{d, "margin-top", ""},
+		{d, "border-top-width", ""},
+	}
+	for _, tt := range tests {
+		if got := doc.ComputedStyle(tt.node)[tt.name]; got != tt.want {
+			t.Errorf("%s of #%s = %q, want %q", tt.name, tt.node.Attributes["id"], got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+)
+
+// Cache of computed values. Computed values of an element depend on its declarations
+// and on inherited values of its parent, so elements with the same matched declarations
+// inside parents which inherit the same values share one map of them, like links
+// in items of lists, which differ by margins only. Text nodes share inherited values
+// the same way. Styles are never changed after styling, so sharing them is safe,
+// like sharing of styles between siblings.
+// Inherited values are grouped: computed values of elements with the same
+// declarations of inherited properties inside the same group are in the same group.
+
+// styleKey is what computed values depend on: the group of the parent, declarations
+// by their positions in the sheets, and root font size for rem units.
+// parent is the parent itself if some declaration may inherit a property,
+// which is not inherited by default.
+type styleKey struct {
+	group        int
+	parent       uintptr
+	declarations string
+	rootFontSize float32
+}
+
+// groupKey is what inherited values depend on
+type groupKey struct {
+	group        int
+	declarations string
+	rootFontSize float32
+}
+
+type cachedStyle struct {
+	specified, computed propertyMap
+}
+
+// styleCache is kept by each goroutine of styling
+type styleCache struct {
+	entries map[styleKey]cachedStyle
+	groups  map[groupKey]int
+	// groupOf has groups of computed values by their maps, which are kept
+	// in maps, so that their addresses are not reused while the cache lives
+	groupOf map[uintptr]int
+	maps    []propertyMap
+	// last is the last group given
+	last int
+}
+
+func newStyleCache() *styleCache {
+	return &styleCache{
+		entries: make(map[styleKey]cachedStyle),
+		groups:  make(map[groupKey]int),
+		groupOf: make(map[uintptr]int),
+	}
+}
+
+// group returns group of the computed values, those styled elsewhere get a group of their own.
+// The group of the root parent, which is nil, is 0.
+func (c *styleCache) group(computed propertyMap) int {
+	if computed == nil {
+		return 0
+	}
+	id := reflect.ValueOf(computed).Pointer()
+	group, ok := c.groupOf[id]
+	if !ok {
+		group = c.newGroup()
+		c.setGroup(computed, group)
+	}
+	return group
+}
+
+func (c *styleCache) newGroup() int {
+	c.last++
+	return c.last
+}
+
+func (c *styleCache) setGroup(computed propertyMap, group int) {
+	c.groupOf[reflect.ValueOf(computed).Pointer()] = group
+	c.maps = append(c.maps, computed)
+}
+
+// computeStyle returns specified and computed values of the node inside parent,
+// from the cache if it has them
+func (s *styler) computeStyle(node *Node, parent propertyMap) (propertyMap, propertyMap) {
+	var matched []matchedDeclaration
+	if node.NodeType == ElementNode {
+		matched = s.ctx.cascade(node, "", s.sheets, s.media, s.ancestors)
+	}
+	declarations, inherited, inheritsParent, ok := declarationKeys(matched)
+	if !ok {
+		specified := specifiedValues(matched)
+		return specified, computeValues(specified, parent, s.rootFontSize)
+	}
+	group := s.styles.group(parent)
+	key := styleKey{group, 0, declarations, s.rootFontSize}
+	if inheritsParent {
+		key.parent = reflect.ValueOf(parent).Pointer()
+	}
+	if style, ok := s.styles.entries[key]; ok {
+		return style.specified, style.computed
+	}
+	specified := specifiedValues(matched)
+	computed := computeValues(specified, parent, s.rootFontSize)
+	s.styles.entries[key] = cachedStyle{specified, computed}
+	gkey := groupKey{group, inherited, s.rootFontSize}
+	id, ok := s.styles.groups[gkey]
+	if !ok {
+		id = s.styles.newGroup()
+		s.styles.groups[gkey] = id
+	}
+	s.styles.setGroup(computed, id)
+	return specified, computed
+}
+
+// declarationKeys returns keys of the matched declarations and of those of inherited properties,
+// inheritsParent is set if some declaration may inherit a property, which is not inherited by default.
+// ok is false if the declarations can't be cached: those of hints and style attribute are not in sheets.
+func declarationKeys(matched []matchedDeclaration) (declarations, inherited string, inheritsParent, ok bool) {
+	all := make([]byte, 0, 8*len(matched))
+	own := []byte{}
+	for i := range matched {
+		m := &matched[i]
+		if m.inline || m.position[0] < 0 {
+			return "", "", false, false
+		}
+		start := len(all)
+		for _, p := range m.position {
+			all = strconv.AppendInt(all, int64(p), 36)
+			all = append(all, ' ')
+		}
+		key := all[start:]
+		if def, ok := properties[m.decl.name]; ok && def.inherited || isCustomProperty(m.decl.name) {
+			own = append(own, key...)
+		} else if m.decl.value.isKeyword("inherit") || containsVar(m.decl.value) {
+			inheritsParent = true
+		}
+	}
+	return string(all), string(own), inheritsParent, true
+}
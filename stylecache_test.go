@@ -0,0 +1,29 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestComputedValuesCache(t *testing.T) {
+	html := `<div><p id="a"><a>1</a><span>1</span></p><p id="b"><a>2</a><span>2</span></p><p id="c"><a>3</a></p><p id="d" style="color: #000001"><a>4</a></p></div>`
+	css := `#a {margin-left: 1px} #b {margin-left: 2px} #c {color: #ff0000} span {margin-left: inherit}`
+	div := styleString(t, html, css).children[0]
+	a, b, c, d := div.children[0], div.children[1], div.children[2], div.children[3]
+	same := func(x, y *styledNode) bool {
+		return reflect.ValueOf(x.computedValues).Pointer() == reflect.ValueOf(y.computedValues).Pointer()
+	}
+	if !same(a.children[0], b.children[0]) || !same(a.children[0].children[0], b.children[0].children[0]) {
+		t.Error("links and their text in parents, which inherit the same values, should share style")
+	}
+	if same(a.children[0], c.children[0]) || c.children[0].computedValues["color"].color.R != 255 {
+		t.Error("link should inherit color of its parent")
+	}
+	if same(a.children[1], b.children[1]) || a.children[1].computedValues["margin-left"].toPx() != 1 ||
+		b.children[1].computedValues["margin-left"].toPx() != 2 {
+		t.Error("spans should inherit margins of their parents")
+	}
+	if d.children[0].computedValues["color"].color.B != 1 {
+		t.Error("link should inherit color of style attribute")
+	}
+}
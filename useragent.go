@@ -0,0 +1,120 @@
+package main
+
+import (
+	"log"
+	"strings"
+)
+
+// userAgentCSS is the default stylesheet of the user-agent origin, it follows
+// the rendering section of HTML spec, https://html.spec.whatwg.org/multipage/rendering.html
+// Shorthands are written as longhands, because layout looks only at those.
+const userAgentCSS = `
+[hidden], area, base, basefont, datalist, head, link, meta, noembed,
+noframes, param, rp, script, style, template, title {
+	display: none;
+}
+
+html, body, address, blockquote, center, dialog, div, figure, figcaption,
+footer, form, header, hr, legend, listing, main, p, plaintext, pre, search, xmp,
+article, aside, h1, h2, h3, h4, h5, h6, hgroup, nav, section,
+dir, dd, dl, dt, menu, ol, ul, details, fieldset, optgroup {
+	display: block;
+}
+
+li, summary { display: list-item; }
+
+table { display: table; }
+caption { display: table-caption; }
+colgroup { display: table-column-group; }
+col { display: table-column; }
+thead { display: table-header-group; }
+tbody { display: table-row-group; }
+tfoot { display: table-footer-group; }
+tr { display: table-row; }
+td, th { display: table-cell; }
+
+input, select, button, textarea, img, video, canvas, iframe, embed, object {
+	display: inline-block;
+}
+
+body {
+	margin-top: 8px;
+	margin-right: 8px;
+	margin-bottom: 8px;
+	margin-left: 8px;
+}
+
+blockquote, figure, listing, p, plaintext, pre, xmp, dir, dl, menu, ol, ul {
+	margin-top: 1em;
+	margin-bottom: 1em;
+}
+
+blockquote, figure {
+	margin-left: 40px;
+	margin-right: 40px;
+}
+
+dir dir, dir dl, dir menu, dir ol, dir ul, dl dir, dl dl, dl menu, dl ol, dl ul,
+menu dir, menu dl, menu menu, menu ol, menu ul, ol dir, ol dl, ol menu, ol ol, ol ul,
+ul dir, ul dl, ul menu, ul ol, ul ul {
+	margin-top: 0;
+	margin-bottom: 0;
+}
+
+dir, menu, ol, ul { padding-left: 40px; }
+dd { margin-left: 40px; }
+ol { list-style-type: decimal; }
+ul, dir, menu { list-style-type: disc; }
+
+h1 { margin-top: 0.67em; margin-bottom: 0.67em; font-size: 2em; font-weight: bold; }
+h2 { margin-top: 0.83em; margin-bottom: 0.83em; font-size: 1.5em; font-weight: bold; }
+h3 { margin-top: 1em; margin-bottom: 1em; font-size: 1.17em; font-weight: bold; }
+h4 { margin-top: 1.33em; margin-bottom: 1.33em; font-size: 1em; font-weight: bold; }
+h5 { margin-top: 1.67em; margin-bottom: 1.67em; font-size: 0.83em; font-weight: bold; }
+h6 { margin-top: 2.33em; margin-bottom: 2.33em; font-size: 0.67em; font-weight: bold; }
+
+hr {
+	margin-top: 0.5em;
+	margin-bottom: 0.5em;
+	border-width: 1px;
+	border-style: inset;
+	color: #808080;
+}
+
+address, cite, dfn, em, i, var { font-style: italic; }
+b, strong, th { font-weight: bolder; }
+code, kbd, listing, plaintext, pre, samp, tt, xmp { font-family: monospace; }
+pre, listing, plaintext, xmp { white-space: pre; }
+small { font-size: smaller; }
+big { font-size: larger; }
+sub { vertical-align: sub; font-size: smaller; }
+sup { vertical-align: super; font-size: smaller; }
+u, ins { text-decoration: underline; }
+s, strike, del { text-decoration: line-through; }
+mark { background-color: #ffff00; color: #000000; }
+a[href] { color: #0000ee; text-decoration: underline; }
+
+center, th { text-align: center; }
+table { border-spacing: 2px; border-collapse: separate; }
+td, th { padding-top: 1px; padding-right: 1px; padding-bottom: 1px; padding-left: 1px; }
+caption { text-align: center; }
+td, th, tr, thead, tbody, tfoot { vertical-align: middle; }
+
+q::before { content: open-quote; }
+q::after { content: close-quote; }
+`
+
+var userAgentStyle = parseUserAgentStylesheet()
+
+func parseUserAgentStylesheet() *Stylesheet {
+	s, err := ParseStylesheetOrigin(strings.NewReader(userAgentCSS), UserAgentOrigin)
+	if err != nil {
+		log.Fatalln("user agent stylesheet is broken.", err)
+	}
+	return s
+}
+
+// UserAgentStylesheet returns the default stylesheet, which all documents are styled with
+func UserAgentStylesheet() *Stylesheet {
+	return userAgentStyle
+}
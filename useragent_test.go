@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUserAgentStylesheet(t *testing.T) {
+	html := `<html><head><title>t</title></head><body><h1>h</h1><ul><li>i</li></ul><span>s</span><p hidden="">x</p></body></html>`
+	node, err := parseHTMLWrapped(strings.NewReader(html))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := styleTree(node, UserAgentStylesheet())
+	htmlNode := st.children[0]
+	head, body := htmlNode.children[0], htmlNode.children[1]
+	h1, ul, span, p := body.children[0], body.children[1], body.children[2], body.children[3]
+
+	displays := []struct {
+		name string
+		node *styledNode
+		want displayType
+	}{
+		{"head", head, none},
+		{"body", body, block},
+		{"h1", h1, block},
+		{"li", ul.children[0], block},
+		{"span", span, inline},
+		{"hidden", p, none},
+	}
+	for _, d := range displays {
+		if got := d.node.displayType(); got != d.want {
+			t.Errorf("%s: display %v, want %v", d.name, got, d.want)
+		}
+	}
+	if v := h1.specifiedValues["font-size"]; v.unitType != Em || v.length != 2 {
+		t.Error("h1 font-size", v)
+	}
+	if v := ul.specifiedValues["padding-left"]; v.toPx() != 40 {
+		t.Error("ul padding-left", v)
+	}
+	if box := nodesToBoxes(htmlNode); len(box.children) != 1 {
+		t.Error("head should have no box, got", len(box.children))
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Custom properties and var(), https://www.w3.org/TR/css-variables-1/
+// Custom properties keep the raw text of their values and inherit. Their var() references
+// are substituted at computed-value time, then var() in other properties. A property,
+// which references an invalid custom property without fallback, is invalid
+// at computed-value time and behaves as unset.
+
+func isCustomProperty(name string) bool {
+	return strings.HasPrefix(name, "--")
+}
+
+func isNextCustomProperty(r *bufio.Reader) bool {
+	next, _ := r.Peek(2)
+	return string(next) == "--"
+}
+
+// errInvalidTokens is returned for a custom property with a stray closing bracket,
+// which is read up to the end of the declaration, so that only the declaration is dropped
+var errInvalidTokens = errors.New("unbalanced closing bracket in custom property")
+
+// readTokens reads the raw value of custom property up to ; ! or } out of blocks and strings
+func readTokens(r *bufio.Reader) (Value, error) {
+	s := new(strings.Builder)
+	closing := []rune{}
+	invalid := false
+	for {
+		c, _, err := r.ReadRune()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return Value{}, err
+		}
+		if len(closing) == 0 && (c == ';' || c == '}' || c == '!' && !invalid) {
+			r.UnreadRune()
+			break
+		}
+		switch c {
+		case '"', '\'':
+			r.UnreadRune()
+			str, err := readString(r)
+			if err != nil {
+				return Value{}, err
+			}
+			s.WriteString(quoteString(str))
+			continue
+		case '(':
+			closing = append(closing, ')')
+		case '[':
+			closing = append(closing, ']')
+		case '{':
+			closing = append(closing, '}')
+		case ')', ']', '}':
+			if len(closing) == 0 || closing[len(closing)-1] != c {
+				invalid = true
+				continue
+			}
+			closing = closing[:len(closing)-1]
+		}
+		s.WriteRune(c)
+	}
+	if len(closing) > 0 {
+		return Value{}, fmt.Errorf("%q is expected in custom property", closing[len(closing)-1])
+	}
+	if invalid {
+		return Value{}, errInvalidTokens
+	}
+	return Value{valueType: Tokens, text: strings.TrimSpace(s.String())}, nil
+}
+
+// containsVar tells if the value has var() functions
+func containsVar(v Value) bool {
+	switch v.valueType {
+	case FunctionValue, Pending:
+		if v.function == "var" {
+			return true
+		}
+		for _, arg := range v.args {
+			if containsVar(arg) {
+				return true
+			}
+		}
+	case ListValue:
+		for _, item := range v.list {
+			if containsVar(item) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// customPropertiesKey is the entry of computed values, which keeps their custom properties.
+// Elements, which declare none, share the map of their parent. "--" is reserved by the spec,
+// and specified "--" is kept in the map anyway.
+const customPropertiesKey = "--"
+
+// customProperties returns computed custom properties of computed values, inherited ones included
+func customProperties(computed propertyMap) propertyMap {
+	return computed[customPropertiesKey].vars
+}
+
+// withCustomProperties returns computed values with custom properties as separate entries
+func withCustomProperties(computed propertyMap) propertyMap {
+	custom := customProperties(computed)
+	result := make(propertyMap, len(computed)+len(custom))
+	for name, v := range computed {
+		if name != customPropertiesKey {
+			result[name] = v
+		}
+	}
+	for name, v := range custom {
+		result[name] = v
+	}
+	return result
+}
+
+// substituteVariables returns specified values without custom properties, with var()
+// substituted, and computed custom properties, inherited ones included. Custom properties,
+// which are invalid at computed-value time, are missing, other invalid properties are unset.
+// Custom properties of the parent are returned as is if the element declares none.
+func substituteVariables(specified, parent propertyMap) (values, custom propertyMap) {
+	inherited := customProperties(parent)
+	declares, references := false, false
+	for name, v := range specified {
+		if isCustomProperty(name) {
+			declares = true
+		} else if containsVar(v) {
+			references = true
+		}
+	}
+	if !declares && !references {
+		return specified, inherited
+	}
+	resolver := &variableResolver{specified: specified, parent: inherited, values: make(map[string]*Value), cyclic: make(map[string]bool)}
+	custom = inherited
+	if declares {
+		custom = make(propertyMap, len(inherited)+len(specified))
+		for name, v := range inherited {
+			custom[name] = v
+		}
+	}
+	values = make(propertyMap, len(specified))
+	for name, v := range specified {
+		switch {
+		case isCustomProperty(name):
+			if resolved, ok := resolver.resolve(name); ok {
+				custom[name] = resolved
+			} else {
+				delete(custom, name)
+			}
+		case containsVar(v):
+			values[name] = keywordValue("unset")
+			if parsed, ok := resolver.substituteValue(name, v); ok {
+				values[name] = parsed
+			}
+		default:
+			values[name] = v
+		}
+	}
+	return values, custom
+}
+
+// parseValueText parses the whole text as a value
+func parseValueText(text string) (Value, error) {
+	r := bufio.NewReader(strings.NewReader(text))
+	v, err := parseValue(r)
+	if err != nil {
+		return v, err
+	}
+	skipSpaces(r)
+	if !isNextCharEOF(r) {
+		return v, fmt.Errorf("unexpected %q after value", peekAndUnread(r))
+	}
+	return v, nil
+}
+
+// variableResolver computes custom properties of an element on demand
+type variableResolver struct {
+	specified, parent propertyMap
+	// values are computed custom properties, nil for invalid ones
+	values map[string]*Value
+	// resolving are properties being computed, which refer to each other,
+	// those on a cycle of references are cyclic
+	resolving []string
+	cyclic    map[string]bool
+}
+
+// resolve returns computed value of the custom property, false if it is invalid at computed-value time
+func (res *variableResolver) resolve(name string) (Value, bool) {
+	if v, ok := res.values[name]; ok {
+		if v == nil {
+			return Value{}, false
+		}
+		return *v, true
+	}
+	for i, n := range res.resolving {
+		if n == name {
+			for _, member := range res.resolving[i:] {
+				res.cyclic[member] = true
+			}
+			return Value{}, false
+		}
+	}
+	specified, ok := res.specified[name]
+	if !ok || specified.valueType == Tokens && (specified.text == "inherit" || specified.text == "unset") {
+		v, ok := res.parent[name]
+		return v, ok
+	}
+	text := tokensText(specified)
+	if text == "initial" {
+		res.values[name] = nil
+		return Value{}, false
+	}
+	res.resolving = append(res.resolving, name)
+	text, ok = res.substitute(text)
+	res.resolving = res.resolving[:len(res.resolving)-1]
+	if !ok || res.cyclic[name] {
+		res.values[name] = nil
+		return Value{}, false
+	}
+	v := Value{valueType: Tokens, text: text}
+	res.values[name] = &v
+	return v, true
+}
+
+// tokensText returns raw text of the custom property value, which may be parsed if set by CSSOM
+func tokensText(v Value) string {
+	if v.valueType == Tokens {
+		return v.text
+	}
+	return v.String()
+}
+
+// substitute replaces var() functions in the text with values of custom properties,
+// or their fallbacks, false if some reference is invalid without fallback
+func (res *variableResolver) substitute(text string) (string, bool) {
+	s := new(strings.Builder)
+	for i := 0; i < len(text); {
+		switch {
+		case text[i] == '"' || text[i] == '\'':
+			end := stringEnd(text, i)
+			s.WriteString(text[i:end])
+			i = end
+		case strings.HasPrefix(strings.ToLower(text[i:]), "var(") && (i == 0 || !identChar.MatchString(text[i-1:i])):
+			end := closingParen(text, i+4)
+			if end < 0 {
+				return "", false
+			}
+			name, fallback, hasFallback := cutTopLevel(text[i+4 : end])
+			name = strings.TrimSpace(name)
+			if !isCustomProperty(name) {
+				return "", false
+			}
+			if v, ok := res.resolve(name); ok {
+				s.WriteString(tokensText(v))
+			} else if !hasFallback {
+				return "", false
+			} else if sub, ok := res.substitute(strings.TrimSpace(fallback)); ok {
+				s.WriteString(sub)
+			} else {
+				return "", false
+			}
+			i = end + 1
+		default:
+			s.WriteByte(text[i])
+			i++
+		}
+	}
+	return s.String(), true
+}
+
+// substituteValue substitutes var() in the value of the property, the value of pending
+// longhand is taken from its shorthand, false if the value is invalid at computed-value time
+func (res *variableResolver) substituteValue(name string, v Value) (Value, bool) {
+	source := v
+	if v.valueType == Pending {
+		source = v.args[0]
+	}
+	text, ok := res.substitute(source.String())
+	if !ok {
+		return Value{}, false
+	}
+	parsed, err := parseValueText(text)
+	if err != nil {
+		return Value{}, false
+	}
+	if v.valueType == Pending {
+		return pendingValue(v.function, name, parsed)
+	}
+	return parsed, true
+}
+
+// stringEnd returns the index after the string starting at i
+func stringEnd(text string, i int) int {
+	quote := text[i]
+	for j := i + 1; j < len(text); j++ {
+		if text[j] == '\\' {
+			j++
+		} else if text[j] == quote {
+			return j + 1
+		}
+	}
+	return len(text)
+}
+
+// closingParen returns index of ) closing parenthesis opened before start, -1 if there is none
+func closingParen(text string, start int) int {
+	depth := 0
+	for i := start; i < len(text); i++ {
+		switch text[i] {
+		case '"', '\'':
+			i = stringEnd(text, i) - 1
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+// cutTopLevel cuts the text around the first comma out of parentheses and strings
+func cutTopLevel(text string) (before, after string, found bool) {
+	depth := 0
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '"', '\'':
+			i = stringEnd(text, i) - 1
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				return text[:i], text[i+1:], true
+			}
+		}
+	}
+	return text, "", false
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_readTokens(t *testing.T) {
+	tests := []struct {
+		css       string
+		name      string
+		value     string
+		important bool
+	}{
+		{"--Main-Color: #ff0000", "--Main-Color", "#ff0000", false},
+		{"--block: { a: b; c: [d] } ;", "--block", "{ a: b; c: [d] }", false},
+		{"--text: 'a;b' (x, y)!important", "--text", `"a;b" (x, y)`, true},
+		{"--empty:;", "--empty", "", false},
+	}
+	for _, tt := range tests {
+		d, err := parseDeclarator(bufio.NewReader(strings.NewReader(tt.css)))
+		if err != nil {
+			t.Fatal(tt.css, err)
+		}
+		if d.name != tt.name || d.value.valueType != Tokens || d.value.text != tt.value || d.important != tt.important {
+			t.Errorf("%q: got %s", tt.css, d)
+		}
+	}
+	for _, css := range []string{"--x: (]", "--x: [a", "--x: 'a"} {
+		if _, err := parseDeclarator(bufio.NewReader(strings.NewReader(css))); err == nil {
+			t.Errorf("%q is accepted", css)
+		}
+	}
+	r := bufio.NewReader(strings.NewReader("--x: a) (b) !important; color: red"))
+	if _, err := parseDeclarator(r); !errors.Is(err, errInvalidTokens) {
+		t.Errorf("stray ) gives %v", err)
+	}
+	if rest, _ := r.ReadString(0); rest != "; color: red" {
+		t.Errorf("declaration with stray ) is read up to %q", rest)
+	}
+}
+
+func TestInvalidCustomPropertyIsDropped(t *testing.T) {
+	sheet := mustParseStylesheet(t, "p {--x: a); color: #ff0000; --y: ]} div {--z: 1px}")
+	if len(sheet.rules) != 2 {
+		t.Fatalf("got %d rules", len(sheet.rules))
+	}
+	if got := sheet.rules[0].declarators; len(got) != 1 || got[0].name != "color" {
+		t.Errorf("got declarations %v", got)
+	}
+	if got := sheet.rules[1].declarators; len(got) != 1 || got[0].name != "--z" {
+		t.Errorf("got declarations %v after invalid ones", got)
+	}
+}
+
+func TestCustomProperties(t *testing.T) {
+	html := `<div id="root"><p id="p">text</p><span id="cycle">s</span></div>`
+	css := `div {--gap: 4px; --color: #ff0000; --size: 2em; font-size: 10px; color: #000001; width: 50px}
+		p {--double: var(--gap) var(--gap); --nested: var(--missing, var(--gap)); --Case: 1px;
+			color: var(--color); text-indent: var(--nested); margin: var(--double); font-size: var(--size);
+			word-spacing: var(--case, 5px); letter-spacing: var(--missing); width: var(--missing)}
+		span {--a: var(--b); --b: var(--a); --c: var(--a, 3px); --d: var(--b, 1px) var(--c);
+			--e: initial; color: var(--a); text-indent: var(--c); width: var(--e, 7px)}`
+	node, err := parseHTMLWrapped(strings.NewReader(html))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := NewStyledDocument(node, mustParseStylesheet(t, css))
+	p, span := node.Children[0].Children[0], node.Children[0].Children[1]
+	tests := []struct {
+		node        *Node
+		name, value string
+	}{
+		{p, "--gap", "4px"},
+		{p, "--double", "4px 4px"},
+		{p, "--nested", "4px"},
+		{p, "--Case", "1px"},
+		{p, "color", "rgb(255, 0, 0)"},
+		{p, "text-indent", "4px"},
+		{p, "margin", "4px 4px"},
+		{p, "font-size", "20px"},
+		{p, "word-spacing", "5px"},
+		{p, "letter-spacing", "normal"},
+		{p, "width", "auto"},
+		{span, "color", "rgb(0, 0, 1)"},
+		{span, "text-indent", "3px"},
+		{span, "width", "7px"},
+		{span, "--d", "1px 3px"},
+	}
+	for _, tt := range tests {
+		if got := doc.ComputedStyle(tt.node)[tt.name]; got != tt.value {
+			t.Errorf("%s of %s: got %q, want %q", tt.name, tt.node.Attributes["id"], got, tt.value)
+		}
+	}
+	style := doc.ComputedStyle(span)
+	for _, name := range []string{"--a", "--b", "--e"} {
+		if v, ok := style[name]; ok {
+			t.Errorf("invalid %s is %q", name, v)
+		}
+	}
+	if got := doc.ComputedStyle(p.Children[0])["--color"]; got != "#ff0000" {
+		t.Errorf("text inherits %q", got)
+	}
+	text, parent := customProperties(doc.styled[p.Children[0]].computedValues), customProperties(doc.styled[p].computedValues)
+	if reflect.ValueOf(text).Pointer() != reflect.ValueOf(parent).Pointer() {
+		t.Error("text doesn't share custom properties of its parent")
+	}
+
+	doc.SetAttribute(node.Children[0], "style", "--gap: 1px; --color: var(--unknown)")
+	doc.Restyle()
+	compareStyledTrees(t, "custom property", doc.root, NewStyledDocument(node, mustParseStylesheet(t, css)).root)
+	if got := doc.ComputedStyle(p)["margin"]; got != "1px 1px" {
+		t.Errorf("got margin %s after change", got)
+	}
+	if got := doc.ComputedStyle(p)["color"]; got != "rgb(0, 0, 1)" {
+		t.Errorf("got color %s for invalid variable", got)
+	}
+}